@@ -0,0 +1,28 @@
+//go:build linux
+// +build linux
+
+package dirtree
+
+import "syscall"
+
+// FrsizeSupported tells whether the fundamental block size of the
+// filesystems can be used, see Scanner.UseFrsize.
+const FrsizeSupported = true
+
+// Get filesystem block size.
+//
+// Returns block size in bytes or error. If `frsize` is true the fundamental
+// block size (f_frsize) is returned instead of the optimal transfer block
+// size (f_bsize). The space is allocated in the units of the former, the two
+// are the same on most filesystems but not on all of them.
+// https://man7.org/linux/man-pages/man2/statfs.2.html
+func getFSBlockSize(path string, frsize bool) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	} else if frsize && stat.Frsize > 0 {
+		return int64(stat.Frsize), nil
+	} else {
+		return int64(stat.Bsize), nil
+	}
+}
@@ -0,0 +1,28 @@
+//go:build linux
+// +build linux
+
+package dirtree
+
+import (
+	"syscall"
+	"testing"
+)
+
+func Test_GetFSBlockSize(t *testing.T) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(".", &stat); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The two are usually the same, but they don't have to be
+	bsize, err := getFSBlockSize(".", false)
+	if err != nil || bsize != int64(stat.Bsize) {
+		t.Errorf("Expecting f_bsize %d and not %d (%v)", stat.Bsize, bsize, err)
+	}
+	frsize, err := getFSBlockSize(".", true)
+	if err != nil || frsize != int64(stat.Frsize) {
+		t.Errorf("Expecting f_frsize %d and not %d (%v)", stat.Frsize, frsize, err)
+	}
+	if _, err := getFSBlockSize("./ak5i8fg74", true); err == nil {
+		t.Errorf("Expecting an error for a missing path")
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package dirtree
+
+import "syscall"
+
+// FrsizeSupported tells whether the fundamental block size of the
+// filesystems can be used, see Scanner.UseFrsize. The statfs of the other
+// systems has no f_frsize.
+const FrsizeSupported = false
+
+// Get filesystem block size.
+//
+// Returns the block size (f_bsize) in bytes or error. The `frsize` is
+// ignored, see FrsizeSupported.
+func getFSBlockSize(path string, frsize bool) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bsize), nil
+}
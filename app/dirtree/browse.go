@@ -0,0 +1,162 @@
+package dirtree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// Actions the user can take while browsing a tree.
+const (
+	actionNone = iota
+	actionUp
+	actionDown
+	actionOpen
+	actionBack
+	actionQuit
+)
+
+// A directory being browsed and the selected sub-directory in it.
+type browseFrame struct {
+	dir     *DirTree
+	subdirs []*DirTree // sub-directories sorted by size, biggest first
+	cursor  int
+}
+
+// browser keeps the state of an interactive session. The last frame is the
+// current directory, the ones before it are its parents.
+type browser struct {
+	frames []browseFrame
+	opts   *PrintOptions
+}
+
+// Browse lets the user navigate `dt` interactively. It reads key presses
+// from `in` and draws the current directory to `out` after each one, until
+// the user quits or `in` ends. Up and Down (or k and j) move the selection,
+// Right or Enter (or l) opens the selected sub-directory, Left or Backspace
+// (or h) goes back to the parent directory and q quits.
+//
+// The tree is not rescanned while browsing. `in` is expected to be a
+// terminal in raw mode, so lines are terminated by "\r\n".
+func (dt *DirTree) Browse(in io.Reader, out io.Writer, opts PrintOptions) error {
+	b := &browser{opts: &opts}
+	b.open(dt)
+	r := bufio.NewReader(in)
+	for {
+		if err := b.draw(out); err != nil {
+			return err
+		}
+		action, err := readAction(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if action == actionQuit {
+			return nil
+		}
+		b.do(action)
+	}
+}
+
+// readAction reads a single key press from `r` and translates it to an
+// action. Returns actionNone for the keys it doesn't know.
+func readAction(r *bufio.Reader) (int, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return actionNone, err
+	}
+	switch c {
+	case 'k':
+		return actionUp, nil
+	case 'j':
+		return actionDown, nil
+	case 'l', '\r', '\n':
+		return actionOpen, nil
+	case 'h', 127, '\b':
+		return actionBack, nil
+	case 'q', 3: // 3 is Ctrl+C
+		return actionQuit, nil
+	case 27:
+		// Arrow keys are "ESC [ A" to "ESC [ D", which the terminal sends
+		// all at once. A lone ESC does nothing, without waiting for the
+		// next key or swallowing it.
+		if r.Buffered() == 0 {
+			return actionNone, nil
+		}
+		if next, err := r.Peek(1); err != nil || next[0] != '[' {
+			return actionNone, nil
+		}
+		r.ReadByte()
+		if c, err = r.ReadByte(); err != nil {
+			return actionNone, err
+		}
+		switch c {
+		case 'A':
+			return actionUp, nil
+		case 'B':
+			return actionDown, nil
+		case 'C':
+			return actionOpen, nil
+		case 'D':
+			return actionBack, nil
+		}
+	}
+
+	return actionNone, nil
+}
+
+// open makes `dt` the current directory.
+func (b *browser) open(dt *DirTree) {
+	b.frames = append(b.frames, browseFrame{dir: dt, subdirs: SortTrees(dt.subdirs, SortSize, false)})
+}
+
+// current returns the frame of the current directory.
+func (b *browser) current() *browseFrame {
+	return &b.frames[len(b.frames)-1]
+}
+
+// do changes the state of the browser according to `action`.
+func (b *browser) do(action int) {
+	f := b.current()
+	switch action {
+	case actionUp:
+		if f.cursor > 0 {
+			f.cursor--
+		}
+	case actionDown:
+		if f.cursor < len(f.subdirs)-1 {
+			f.cursor++
+		}
+	case actionOpen:
+		if len(f.subdirs) > 0 {
+			b.open(f.subdirs[f.cursor])
+		}
+	case actionBack:
+		if len(b.frames) > 1 {
+			b.frames = b.frames[:len(b.frames)-1]
+		}
+	}
+}
+
+// draw clears the screen and prints out the current directory with its
+// sub-directories, the selected one is marked with ">".
+func (b *browser) draw(out io.Writer) error {
+	f := b.current()
+	dt := f.dir
+	screen := "\033[H\033[2J"
+	screen += fmt.Sprintf(b.opts.Format, dt.sizeField(dt.size, b.opts), fixPath(filepath.Clean(dt.path))) + "\r\n"
+	for i, d := range f.subdirs {
+		mark := "  "
+		if i == f.cursor {
+			mark = "> "
+		}
+		screen += mark + fmt.Sprintf(b.opts.Format, d.sizeField(d.size, b.opts), filepath.Base(d.path)+"/") + "\r\n"
+	}
+	screen += "\r\n[up/down] select  [right/enter] open  [left] back  [q] quit\r\n"
+	_, err := io.WriteString(out, screen)
+
+	return err
+}
@@ -0,0 +1,105 @@
+package dirtree
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Browse(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "small", "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "big", "over_4m.txt"), 5678 * 1024},
+		{filepath.Join(testFilesRoot, "big", "nested", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	opts := PrintOptions{Format: "%d\t%s"}
+	var tests = []struct {
+		name  string
+		keys  string
+		lines []string // the last screen
+	}{
+		{"Start", "q", []string{
+			"11416\t" + testFilesRoot,
+			"> 11392\tbig/",
+			"  16\tsmall/",
+		}},
+		{"Down", "\033[Bq", []string{
+			"11416\t" + testFilesRoot,
+			"  11392\tbig/",
+			"> 16\tsmall/",
+		}},
+		{"Down past the end", "jjjq", []string{
+			"11416\t" + testFilesRoot,
+			"  11392\tbig/",
+			"> 16\tsmall/",
+		}},
+		{"Open", "\rq", []string{
+			"11392\t" + testFilesRoot + "/big",
+			"> 24\tnested/",
+		}},
+		{"Open and back", "j\033[C\033[Dq", []string{
+			"11416\t" + testFilesRoot,
+			"  11392\tbig/",
+			"> 16\tsmall/",
+		}},
+		{"Back from the root", "hhkq", []string{
+			"11416\t" + testFilesRoot,
+			"> 11392\tbig/",
+			"  16\tsmall/",
+		}},
+		{"Escape", "\033jq", []string{
+			"11416\t" + testFilesRoot,
+			"  11392\tbig/",
+			"> 16\tsmall/",
+		}},
+		{"End of input", "ll", []string{
+			"24\t" + testFilesRoot + "/big/nested",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := dt.Browse(strings.NewReader(tt.keys), &out, opts); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			screens := strings.Split(out.String(), "\033[H\033[2J")
+			got := strings.Split(screens[len(screens)-1], "\r\n")
+			for i, v := range tt.lines {
+				if i >= len(got) || got[i] != v {
+					t.Errorf("Expecting screen line #%v to be %q, got screen %q", i, v, got)
+					break
+				}
+			}
+		})
+	}
+}
+
+func Test_ReadActionEscape(t *testing.T) {
+	// A lone ESC doesn't wait for the next key
+	r, w := io.Pipe()
+	defer w.Close()
+	go w.Write([]byte{27})
+	done := make(chan int, 1)
+	go func() {
+		action, _ := readAction(bufio.NewReader(r))
+		done <- action
+	}()
+	select {
+	case action := <-done:
+		if action != actionNone {
+			t.Errorf("Expecting no action for a lone ESC and not %d", action)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expecting a lone ESC not to wait for the next key")
+	}
+}
@@ -0,0 +1,57 @@
+package dirtree
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// addUsage adds the apparent and the allocated sizes of the file described by
+// `info` to the totals of `dt`.
+func (dt *DirTree) addUsage(info os.FileInfo) {
+	dt.apparentBytes, _ = addCapped(dt.apparentBytes, info.Size())
+	dt.allocatedBytes, _ = addCapped(dt.allocatedBytes, dt.allocated(info))
+}
+
+// allocated returns the number of bytes allocated to the file described by
+// `info`. If the filesystem doesn't tell, it is estimated from the size of
+// the file and the block size.
+func (dt *DirTree) allocated(info os.FileInfo) int64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		// st_blocks is always in 512-byte blocks
+		return int64(st.Blocks) * 512
+	}
+	if info.Size() == 0 {
+		return 0
+	}
+	return (1 + (info.Size()-1)/dt.blockSize) * dt.blockSize
+}
+
+// compressionRatio returns how many bytes of the files are stored in each
+// byte allocated for them. It is greater than 1 if the filesystem compresses
+// the files (or they are sparse) and 0 if nothing is allocated.
+func compressionRatio(apparent, allocated int64) float64 {
+	if allocated == 0 {
+		return 0
+	}
+	return float64(apparent) / float64(allocated)
+}
+
+// PrintCompression walks over the directories of `dt` in the same order as
+// Print and returns a line for each with its allocated size, apparent size
+// and the compression ratio between the two, separated by tabs. On a
+// filesystem with transparent compression, such as ZFS or Btrfs, this shows
+// the savings. The `opts.Format` is ignored, files are never printed.
+func (dt *DirTree) PrintCompression(opts PrintOptions) []string {
+	var out []string
+	if !opts.Summarise && opts.withinDepth(dt.depth+1) {
+		for _, d := range SortTrees(dt.subdirs, opts.Sort, opts.Reverse) {
+			out = append(out, d.PrintCompression(opts)...)
+		}
+	}
+	allocated := dt.sizeField(dt.toUnits(dt.allocatedBytes), &opts)
+	apparent := dt.sizeField(dt.toUnits(dt.apparentBytes), &opts)
+	ratio := compressionRatio(dt.apparentBytes, dt.allocatedBytes)
+
+	return append(out, fmt.Sprintf("%d\t%d\t%.2f\t%s", allocated, apparent, ratio, opts.path(dt.dirEntry())))
+}
@@ -0,0 +1,64 @@
+package dirtree
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func Test_CompressionRatio(t *testing.T) {
+	var tests = []struct {
+		apparent, allocated int64
+		want                float64
+	}{
+		{8192, 4096, 2},
+		{1000, 4096, 1000.0 / 4096},
+		{0, 0, 0},
+		{100, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := compressionRatio(tt.apparent, tt.allocated); got != tt.want {
+			t.Errorf("Expecting the ratio of %d to %d to be %v and not %v", tt.apparent, tt.allocated, tt.want, got)
+		}
+	}
+}
+
+func Test_PrintCompression(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	// A sparse file has nothing allocated, just like a file that is
+	// compressed well
+	sparse, err := os.Create(filepath.Join(testFilesRoot, "sparse"))
+	if err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	if err := sparse.Truncate(1 << 20); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	sparse.Close()
+
+	var apparent, allocated int64
+	for _, p := range []string{testFilesRoot, files[0].path, sparse.Name()} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		apparent += info.Size()
+		allocated += info.Sys().(*syscall.Stat_t).Blocks * 512
+	}
+
+	dt := New(testFilesRoot, 1)
+	out := dt.PrintCompression(PrintOptions{})
+	ratio := strconv.FormatFloat(float64(apparent)/float64(allocated), 'f', 2, 64)
+	want := strconv.FormatInt(allocated, 10) + "\t" + strconv.FormatInt(apparent, 10) + "\t" + ratio + "\t" + testFilesRoot
+	if len(out) != 1 || out[0] != want {
+		t.Errorf("Expecting output %q and not %q", want, out)
+	}
+}
@@ -0,0 +1,46 @@
+package dirtree
+
+import "fmt"
+
+// depthLevel is the number and the total size of the directories at one
+// depth of a tree.
+type depthLevel struct {
+	count int64
+	size  int64 // disk usage in units
+}
+
+// depthLevels adds `dt` and all its sub-directories to `levels`, indexed by
+// their depth relative to the root of `dt`, and returns the result.
+func (dt *DirTree) depthLevels(levels []depthLevel, depth int) []depthLevel {
+	if depth == len(levels) {
+		levels = append(levels, depthLevel{})
+	}
+	levels[depth].count++
+	levels[depth].size = levels[depth].size + dt.size
+	for _, d := range dt.subdirs {
+		levels = d.depthLevels(levels, depth+1)
+	}
+
+	return levels
+}
+
+// PrintDepthSummary returns a line for each depth of `dt`, from the root at
+// 0 to the deepest directory, with the total size and the number of the
+// directories at that depth, separated by tabs, for example
+// "11368\t2\t1\t./dir". The size of a directory includes everything in it,
+// so the sizes shrink with the depth by the files above. This gives the
+// shape of the tree at a glance. A file makes up a single level with no
+// directories. The `opts.Format` is ignored.
+func (dt *DirTree) PrintDepthSummary(opts PrintOptions) []string {
+	levels := dt.depthLevels(nil, 0)
+	if dt.file {
+		levels[0].count = 0
+	}
+	path := opts.path(dt.dirEntry())
+	var out []string
+	for depth, l := range levels {
+		out = append(out, fmt.Sprintf("%d\t%d\t%d\t%s", dt.sizeField(l.size, &opts), l.count, depth, path))
+	}
+
+	return out
+}
@@ -0,0 +1,37 @@
+package dirtree
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_PrintDepthSummary(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "a", "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "b", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "b", "nested", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	got := dt.PrintDepthSummary(PrintOptions{})
+	want := []string{
+		"80\t1\t0\t" + testFilesRoot,
+		"64\t2\t1\t" + testFilesRoot,
+		"24\t1\t2\t" + testFilesRoot,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting depth summary %q and not %q", want, got)
+	}
+	// A file has no directories below it
+	file := New(testFilesRoot+"/under_4k.txt", 512)
+	want = []string{"8\t0\t0\t" + testFilesRoot + "/under_4k.txt"}
+	if got := file.PrintDepthSummary(PrintOptions{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting depth summary %q for a file and not %q", want, got)
+	}
+}
@@ -0,0 +1,99 @@
+package dirtree
+
+import (
+	"path/filepath"
+	"strconv"
+)
+
+// A directory with its size in bytes, used to compare two trees.
+type diffEntry struct {
+	path  string
+	bytes int64
+}
+
+// PrintDiff compares the directories of `dt` with the ones of `old`, a
+// previous scan of the same hierarchy (see ReadJSON), by their paths. It
+// returns a line for each directory that has grown, shrunk, is new or was
+// removed with the difference in size and the path, for example
+// "+8\t./subdir". The lines for the directories that are new or were removed
+// are marked with "(new)" and "(removed)" respectively. The differences are
+// in the units of `dt` or human readable if `opts.Human` is set, the other
+// options and the `opts.Format` are ignored.
+//
+// If `old` is nil all the directories of `dt` are new.
+func (dt *DirTree) PrintDiff(old *DirTree, opts PrintOptions) []string {
+	var oldDirs []diffEntry
+	if old != nil {
+		oldDirs = old.dirs(&opts)
+	}
+	newDirs := dt.dirs(&opts)
+	oldSizes := make(map[string]int64)
+	for _, d := range oldDirs {
+		oldSizes[d.path] = d.bytes
+	}
+	newSizes := make(map[string]int64)
+	for _, d := range newDirs {
+		newSizes[d.path] = d.bytes
+	}
+
+	var out []string
+	for _, d := range newDirs {
+		prev, ok := oldSizes[d.path]
+		switch {
+		case !ok:
+			out = append(out, dt.formatDelta(d.bytes, &opts)+"\t"+d.path+" (new)")
+		case prev != d.bytes:
+			out = append(out, dt.formatDelta(d.bytes-prev, &opts)+"\t"+d.path)
+		}
+	}
+	for _, d := range oldDirs {
+		if _, ok := newSizes[d.path]; !ok {
+			out = append(out, dt.formatDelta(-d.bytes, &opts)+"\t"+d.path+" (removed)")
+		}
+	}
+
+	return out
+}
+
+// FindTree returns the first of the `trees` rooted at `path` or nil if
+// there is none.
+func FindTree(trees []*DirTree, path string) *DirTree {
+	want := fixPath(filepath.Clean(path))
+	for _, t := range trees {
+		if fixPath(filepath.Clean(t.path)) == want {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// dirs returns all the directories of `dt` with their sizes in bytes, in
+// the order they are printed, with the paths written according to `opts`.
+func (dt *DirTree) dirs(opts *PrintOptions) []diffEntry {
+	var out []diffEntry
+	for _, d := range dt.subdirs {
+		out = append(out, d.dirs(opts)...)
+	}
+	path := opts.path(entry{path: filepath.Clean(dt.path), virtual: dt.virtual})
+
+	return append(out, diffEntry{path: path, bytes: dt.bytes(dt.size)})
+}
+
+// formatDelta returns a difference of `delta` bytes with a sign, in units
+// of `dt` rounded up or human readable.
+func (dt *DirTree) formatDelta(delta int64, opts *PrintOptions) string {
+	sign := "+"
+	if delta < 0 {
+		sign = "-"
+		delta = -delta
+	}
+	if opts.Human {
+		return sign + humanStyled(delta, opts.HumanStyle)
+	}
+	if delta == 0 {
+		return sign + "0"
+	}
+
+	return sign + strconv.FormatInt(1+(delta-1)/dt.unitSize, 10)
+}
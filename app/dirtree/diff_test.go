@@ -0,0 +1,90 @@
+package dirtree
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_PrintDiff(t *testing.T) {
+	trees, err := ReadJSON(strings.NewReader(`
+{"path":"./root","size":40,"bytes":20480,"unit_size":512,"subdirs":[
+	{"path":"./root/grown","size":8,"bytes":4096},
+	{"path":"./root/shrunk","size":16,"bytes":8192},
+	{"path":"./root/same","size":8,"bytes":4096},
+	{"path":"./root/removed","size":8,"bytes":4096}
+]}
+{"path":"./root","size":48,"bytes":24576,"unit_size":512,"subdirs":[
+	{"path":"./root/grown","size":24,"bytes":12288},
+	{"path":"./root/shrunk","size":8,"bytes":4096},
+	{"path":"./root/same","size":8,"bytes":4096},
+	{"path":"./root/new","size":8,"bytes":4096}
+]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(trees) != 2 {
+		t.Fatalf("Expecting 2 trees and not %d", len(trees))
+	}
+
+	var tests = []struct {
+		name   string
+		opts   PrintOptions
+		output []string
+	}{
+		{"Units", PrintOptions{}, []string{
+			"+16\t./root/grown",
+			"-8\t./root/shrunk",
+			"+8\t./root/new (new)",
+			"+8\t./root",
+			"-8\t./root/removed (removed)",
+		}},
+		{"Human", PrintOptions{Human: true}, []string{
+			"+8.0K\t./root/grown",
+			"-4.0K\t./root/shrunk",
+			"+4.0K\t./root/new (new)",
+			"+4.0K\t./root",
+			"-4.0K\t./root/removed (removed)",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := trees[1].PrintDiff(trees[0], tt.opts)
+			if len(out) != len(tt.output) {
+				t.Fatalf("Expecting %d lines and not %d: %q", len(tt.output), len(out), out)
+			}
+			for i, v := range out {
+				if v != tt.output[i] {
+					t.Errorf("Output string #%v %q is not equal to the expected one %q", i, v, tt.output[i])
+				}
+			}
+		})
+	}
+
+	if out := trees[0].PrintDiff(trees[0], PrintOptions{}); len(out) != 0 {
+		t.Errorf("Expecting no differences for the same tree and not %q", out)
+	}
+	if out := trees[0].PrintDiff(nil, PrintOptions{}); len(out) != 5 || out[4] != "+40\t./root (new)" {
+		t.Errorf("Expecting all directories to be new and not %q", out)
+	}
+}
+
+func Test_FindTree(t *testing.T) {
+	trees, err := ReadJSON(strings.NewReader(`{"path":"./a","size":8}{"path":"/b","size":8}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var tests = []struct {
+		path string
+		want *DirTree
+	}{
+		{"a", trees[0]},
+		{"./a/", trees[0]},
+		{"/b", trees[1]},
+		{"b", nil},
+	}
+	for _, tt := range tests {
+		if got := FindTree(trees, tt.path); got != tt.want {
+			t.Errorf("Expecting to find %v for %s and not %v", tt.want, tt.path, got)
+		}
+	}
+}
@@ -3,11 +3,19 @@
 package dirtree
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 // A logger that outputs to stderr without the timestamp.
@@ -15,8 +23,12 @@ var errLog = log.New(os.Stderr, "", 0)
 
 // A simple structs that represents a file in a directory
 type FileInfo struct {
-	path string
-	size int64
+	path   string
+	size   int64
+	length int64 // st_size, the number of bytes in the file
+	depth  int
+	mtime  time.Time
+	blocks int64 // st_blocks, see PrintOptions.Blocks
 }
 
 // A directory tree with accumulated sizes for each directory
@@ -25,6 +37,45 @@ type DirTree struct {
 	path string
 	// Cumulative size of the tree
 	size int64
+	// Cumulative number of files in the tree, not including directories
+	fileCount int64
+	// Number of the files and directories directly in the directory, see
+	// PrintOptions.Children
+	children int64
+	// Cumulative size of the directories themselves, without the files in
+	// them, see PrintBreakdown
+	dirsSize int64
+	// Cumulative apparent (st_size) and allocated (st_blocks) sizes of the
+	// tree in bytes, see PrintCompression
+	apparentBytes, allocatedBytes int64
+	// Nesting level relative to the root of the scan, the root is 0
+	depth int
+	// A virtual tree doesn't exist on the filesystem, its path is just a
+	// name printed as is
+	virtual bool
+	// The directory is on a different filesystem than its parent, i.e. it
+	// is a mount point
+	mount bool
+	// There are no regular files anywhere in the directory, only empty
+	// sub-directories or other kinds of files, see PrintOptions.SkipEmpty
+	empty bool
+	// The root of the scan is a file rather than a directory, see
+	// PrintOptions.Flat
+	file bool
+	// Cumulative size of the tree by the user and the group ids of the
+	// owners, see Scanner.ByOwner and Scanner.ByGroup. They are only kept
+	// at the root of a scan.
+	owners, groups map[uint32]int64
+	// The paths of the regular files with no data in them found anywhere in
+	// the tree, see Scanner.ZeroByteFiles. They are only kept at the root of
+	// a scan.
+	zeroFiles []string
+	// The latest modification time of the directory and anything in it,
+	// see SortMtime
+	mtime time.Time
+	// The blocks allocated to the directory itself (st_blocks), see
+	// PrintOptions.Blocks
+	blocks int64
 	// List of files on the root level
 	files []FileInfo
 	// List of sub-directories
@@ -36,123 +87,958 @@ type DirTree struct {
 	// Filesystem block size. The default is 4k but we will try to get the real
 	// size for each filesystem later.
 	blockSize int64
+	// How sizes are rounded to units.
+	rounding RoundingMode
+	// Use the apparent sizes instead of the disk usage.
+	apparent bool
+	// Use the space allocated to each file (st_blocks) as is.
+	exact bool
+	// Use the apparent sizes of the files smaller than a block.
+	tailPacking bool
 }
 
+// RoundingMode tells how sizes in bytes are rounded to units.
+type RoundingMode int
+
+const (
+	// RoundUp rounds sizes up to the next unit, so that anything that
+	// takes up space takes up at least one unit, this is the default.
+	RoundUp RoundingMode = iota
+	// RoundDown rounds sizes down to the previous unit.
+	RoundDown
+	// RoundNearest rounds sizes to the nearest unit, halfway up.
+	RoundNearest
+)
+
 // New creates a new directory tree rooted at `path`.
 func New(path string, unitSize int64) *DirTree {
-	dt := new(DirTree)
-	dt.path = path
-	dt.unitSize = unitSize
-	bs, err := getFSBlockSize(path)
-	if err != nil {
-		dt.blockSize = 4096
-	} else {
-		dt.blockSize = bs
+	dt, _ := NewScanner(unitSize).Scan(path)
+
+	return dt
+}
+
+// NewWithContext is like New but stops scanning as soon as `ctx` is done.
+// Unlike New it returns the errors of Scanner.ScanContext as well.
+func NewWithContext(ctx context.Context, path string, unitSize int64) (*DirTree, error) {
+	return NewScanner(unitSize).ScanContext(ctx, path)
+}
+
+// Combine returns a virtual tree that has `trees` as its sub-directories and
+// the sum of their sizes as its size. It allows to print a grand total for
+// multiple trees under the `name`.
+func Combine(name string, trees ...*DirTree) *DirTree {
+	dt := &DirTree{path: name, virtual: true, blockSize: defaultBlockSize}
+	for _, t := range trees {
+		dt.size, _ = addCapped(dt.size, t.size)
+		dt.fileCount = dt.fileCount + t.fileCount
+		dt.dirsSize, _ = addCapped(dt.dirsSize, t.dirsSize)
+		dt.apparentBytes, _ = addCapped(dt.apparentBytes, t.apparentBytes)
+		dt.allocatedBytes, _ = addCapped(dt.allocatedBytes, t.allocatedBytes)
+		dt.mergeOwners(t)
+		dt.unitSize = t.unitSize
+		dt.subdirs = append(dt.subdirs, t)
+		dt.children++
 	}
-	dt.buildDirTree()
 
 	return dt
 }
 
 // buildDirTree builds a hierarchy of directories represented by a dirTree
-// structure starting from the directory given by `dt`.
+// structure starting from the directory given by `dt`. The `dtInfo` is the
+// result of stat() on `dt.path`.
+//
+// The files of a directory are scanned before its sub-directories, so that
+// they can be printed out straight away, see Scanner.FilesDone.
 //
 // Any errors encountered during the traversal will be printed to stderr and
 // will not cause the function to fail.
-func (dt *DirTree) buildDirTree() {
-	dtInfo, err := os.Stat(dt.path)
-	if err != nil {
-		errLog.Println(err)
-		return
+func (dt *DirTree) buildDirTree(s *Scanner, dtInfo os.FileInfo) {
+	defer s.dirDone(dt)
+	dt.blockSize = s.blockSize(dt.path, dtInfo)
+	if s.ByOwner {
+		dt.owners = make(map[uint32]int64)
+	}
+	if s.ByGroup {
+		dt.groups = make(map[uint32]int64)
 	}
-	dt.size = dt.calcSize(dtInfo.Size())
 	if !dtInfo.IsDir() {
+		dt.file = true
+		if !s.countable(dtInfo) {
+			return
+		}
+		dt.mtime = dtInfo.ModTime()
+		dt.blocks = stBlocks(dtInfo)
+		dt.size = dt.countableSize(dtInfo)
+		if s.DedupExtents {
+			dt.size = dt.dedupSize(dtInfo, s.sharedBytes(dt.path, dtInfo))
+		}
+		dt.fileCount = 1
+		dt.addUsage(dtInfo)
+		dt.addOwner(dtInfo, dt.size)
+		if s.ZeroByteFiles {
+			dt.addZeroFile(dt.path, dtInfo)
+		}
+		// The user may expect the size of the device rather than its
+		// disk usage
+		if dt.depth == 0 && dtInfo.Mode()&modeSpecial != 0 && !s.quiet {
+			errLog.Printf("'%s' is a device or a special file, only the blocks allocated to it are counted", dt.path)
+		}
 		return
 	}
+	// A symbolic link can lead back to one of the parents
+	ok, leave := s.enter(dtInfo)
+	defer leave()
+	if !ok {
+		s.report(&ScanError{Op: "scan directory", Path: dt.path, Err: errCycle})
+		return
+	}
+	if !s.RegularFilesOnly {
+		dt.size = dt.calcSize(dtInfo.Size())
+		if dt.exact {
+			dt.size = dt.countableSize(dtInfo)
+		}
+		dt.dirsSize = dt.size
+		dt.addUsage(dtInfo)
+	}
+	dt.empty = true
+	dt.mtime = dtInfo.ModTime()
+	dt.blocks = stBlocks(dtInfo)
 
-	files, err := os.ReadDir(dt.path)
+	defer s.readIgnoreRules(dt.path)()
+	s.throttle()
+	files, err := s.readDirTimeout(dt.path)
+	err = s.retry(err, func() (err error) {
+		files, err = s.readDirTimeout(dt.path)
+		return err
+	})
+	if s.DentryOverhead > 0 && !s.RegularFilesOnly {
+		overhead := dt.toUnits(int64(len(files)) * s.DentryOverhead)
+		dt.size = s.addSize(dt, dt.size, overhead)
+		dt.dirsSize = s.addSize(dt, dt.dirsSize, overhead)
+	}
+	dt.addOwner(dtInfo, dt.size)
 	if err != nil {
-		errLog.Println(err)
+		serr := &ScanError{Op: "read directory", Path: dt.path, Err: err}
+		// Only a sub-directory can disappear after its parent was read
+		if dt.depth > 0 {
+			s.reportEntry(serr)
+		} else {
+			s.reportRoot(serr)
+		}
 	}
+	var subdirs []*DirTree
+	var subdirInfos []os.FileInfo
 	for _, f := range files {
-		info, err := f.Info()
+		if s.canceled() {
+			return
+		}
+		path := filepath.Join(dt.path, f.Name())
+		if s.skip(path, f.IsDir()) {
+			continue
+		}
+		s.step()
+		info, err := s.infoTimeout(f, path)
+		err = s.retry(err, func() (err error) {
+			info, err = s.statTimeout(lstat, path)
+			return err
+		})
 		if err != nil {
-			errLog.Println(err)
+			s.reportEntry(&ScanError{Op: "access", Path: path, Err: err})
 			continue
 		}
-		if f.IsDir() {
-			sdt := New(filepath.Join(dt.path, f.Name()), dt.unitSize)
-			dt.size = dt.size + sdt.size
-			dt.subdirs = append(dt.subdirs, sdt)
-		} else {
-			dt.size = dt.size + dt.calcSize(info.Size())
-			fi := FileInfo{
-				path: filepath.Join(dt.path, info.Name()),
-				size: dt.calcSize(info.Size()),
-			}
-			dt.files = append(dt.files, fi)
+		path, info = s.follow(path, info, dt.depth+1)
+		if info.IsDir() && s.OneFileSystem && mountPoint(dtInfo, info) {
+			continue
+		}
+		dt.children++
+		if info.IsDir() {
+			subdirs = append(subdirs, &DirTree{
+				path:        path,
+				unitSize:    dt.unitSize,
+				rounding:    dt.rounding,
+				apparent:    dt.apparent,
+				exact:       dt.exact,
+				tailPacking: dt.tailPacking,
+				depth:       dt.depth + 1,
+				mount:       mountPoint(dtInfo, info),
+			})
+			subdirInfos = append(subdirInfos, info)
+			continue
+		}
+		if !s.countable(info) {
+			continue
+		}
+		// Files with multiple hard links are counted only once
+		if s.seen(info) {
+			continue
+		}
+		size := dt.countableSize(info)
+		if s.DedupExtents {
+			size = dt.dedupSize(info, s.sharedBytes(path, info))
+		}
+		dt.size = s.addSize(dt, dt.size, size)
+		dt.fileCount++
+		if info.Mode().IsRegular() {
+			dt.empty = false
 		}
+		dt.addUsage(info)
+		dt.addOwner(info, size)
+		if s.ZeroByteFiles {
+			dt.addZeroFile(path, info)
+		}
+		if info.ModTime().After(dt.mtime) {
+			dt.mtime = info.ModTime()
+		}
+		if s.SkipFiles {
+			continue
+		}
+		fi := FileInfo{
+			path:   path,
+			size:   size,
+			length: info.Size(),
+			depth:  dt.depth + 1,
+			mtime:  info.ModTime(),
+			blocks: stBlocks(info),
+		}
+		dt.files = append(dt.files, fi)
+	}
+	if s.FilesDone != nil {
+		s.FilesDone(dt)
+	}
+	for i, sdt := range subdirs {
+		if s.canceled() {
+			return
+		}
+		sdt.buildDirTree(s, subdirInfos[i])
+		dt.size = s.addSize(dt, dt.size, sdt.size)
+		dt.fileCount = dt.fileCount + sdt.fileCount
+		dt.dirsSize = s.addSize(dt, dt.dirsSize, sdt.dirsSize)
+		dt.apparentBytes = s.addSize(dt, dt.apparentBytes, sdt.apparentBytes)
+		dt.allocatedBytes = s.addSize(dt, dt.allocatedBytes, sdt.allocatedBytes)
+		dt.empty = dt.empty && sdt.empty
+		if sdt.mtime.After(dt.mtime) {
+			dt.mtime = sdt.mtime
+		}
+		dt.mergeOwners(sdt)
+		sdt.owners, sdt.groups = nil, nil
+		dt.zeroFiles = append(dt.zeroFiles, sdt.zeroFiles...)
+		sdt.zeroFiles = nil
+		dt.subdirs = append(dt.subdirs, sdt)
 	}
 }
 
-// PrintDirTree walks over `dt` recursively and returns a slice of strings.
+// addSize returns the sum of the sizes `a` and `b` of `dt` and its content.
+// A sum that doesn't fit into an int64 wraps around to a negative number and
+// would make the total of a huge tree nonsense, so in this case it is capped
+// at math.MaxInt64 instead and a warning is printed, once for each scan.
+func (s *Scanner) addSize(dt *DirTree, a, b int64) int64 {
+	sum, ok := addCapped(a, b)
+	if ok {
+		return sum
+	}
+	if !s.overflow && !s.quiet {
+		errLog.Printf("the size of '%s' is too big to be counted, the totals are capped at %d", dt.path, int64(math.MaxInt64))
+	}
+	s.overflow = true
 
+	return sum
+}
+
+// addCapped returns the sum of the sizes `a` and `b`, or math.MaxInt64 if it
+// doesn't fit into an int64, and whether it fits.
+func addCapped(a, b int64) (int64, bool) {
+	if a <= math.MaxInt64-b {
+		return a + b, true
+	}
+
+	return math.MaxInt64, false
+}
+
+// bytes returns the `size` in units of an entry of `dt` in bytes. Even when
+// the size in units fits into an int64 the number of bytes may not, so it is
+// capped at math.MaxInt64, or math.MinInt64 for a negative size, like the
+// totals are by addSize.
+func (dt *DirTree) bytes(size int64) int64 {
+	if dt.unitSize <= 1 {
+		return size * dt.unitSize
+	}
+	if size > math.MaxInt64/dt.unitSize {
+		return math.MaxInt64
+	}
+	if size < math.MinInt64/dt.unitSize {
+		return math.MinInt64
+	}
+
+	return size * dt.unitSize
+}
+
+// PrintOptions controls how a directory tree is printed out.
+type PrintOptions struct {
+	// Format for each line, receives the size and the path of an entry.
+	Format string
+	// Print files as well as directories.
+	CountFiles bool
+	// Do not print sub-directories.
+	Summarise bool
+	// Wrap the size of an entry in ANSI colour codes depending on how big
+	// it is, see ColorThresholds.
+	Color bool
+	// Sizes in bytes from which an entry is coloured green, yellow and red
+	// respectively. Entries smaller than the first threshold are left
+	// alone. If not set, DefaultColorThresholds are used.
+	ColorThresholds []int64
+	// Print the total of the root directory first instead of last.
+	TotalAtTop bool
+	// Print sizes in human readable format (e.g. 1.5K, 234M, 2.0G) instead
+	// of units. The sizes are right-aligned so that they line up.
+	Human bool
+	// How the suffixes of the human readable sizes are written.
+	HumanStyle HumanStyle
+	// Do not print files and directories smaller than MinSize bytes. The
+	// sizes of their parents still include them and the root of the tree
+	// is always printed.
+	MinSize int64
+	// If greater than 0, do not print files and directories more than
+	// MaxDepth levels below the root. Their sizes are still included in
+	// the sizes of their parents.
+	MaxDepth int
+	// With ByLeafDepth, only print the directories that are exactly
+	// LeafDepth levels above the deepest directory under them, counting from
+	// the leaves rather than from the root: 0 prints the directories with no
+	// sub-directories, 1 the ones right above them and so on. The root of
+	// the tree is always printed, files only in the printed directories.
+	ByLeafDepth bool
+	LeafDepth   int
+	// With Human, print the size in bytes as well, in a column of its own
+	// before the human readable size (e.g. "11661312\t12M\tdir" with
+	// the "%d\t%s" format).
+	Bytes bool
+	// Print the number of files in each directory (or 1 for a file) in a
+	// column of its own after the size.
+	Counts bool
+	// Print the number of 512-byte blocks the filesystem reports allocated
+	// to each file or directory (st_blocks) in a column of its own after
+	// the size, to check the sizes against. For a directory it is the
+	// blocks of the directory itself, not of anything in it.
+	Blocks bool
+	// Print the number of the files and directories directly in each
+	// directory, e.g. "12 items", in a column of its own after the size
+	// and the other columns, see Summarise.
+	Children bool
+	// How to order the files and the sub-directories of each directory.
+	// Files are still printed before the sub-directories.
+	Sort SortKey
+	// Order the entries the opposite way, e.g. the smallest first.
+	Reverse bool
+	// If greater than 0, shorten the paths longer than Truncate characters
+	// by leaving out the middle, see truncatePath.
+	Truncate int
+	// If greater than 0, pad the sizes in units on the left to at least
+	// PadWidth characters, with spaces or, with ZeroPad, with zeros, so
+	// that the output can be split on fixed columns. Human readable sizes
+	// are not padded.
+	PadWidth int
+	ZeroPad  bool
+	// Indent the JSON printed by PrintJSON for reading it rather than
+	// piping it on.
+	Pretty bool
+	// Mark the directories that are mount points with " [mount]" after the
+	// path, so that it is clear where the filesystem boundaries are.
+	Mounts bool
+	// Do not print the directories without a single regular file anywhere
+	// in them, for example the ones with only empty sub-directories. The
+	// root of the tree is always printed.
+	SkipEmpty bool
+	// Separate the directories in the paths with '/' whatever the
+	// separator of the operating system is, so that the output of the
+	// different systems can be compared, see filepath.ToSlash.
+	Portable bool
+	// List the files only, with no lines for the directories, not even
+	// the roots, like a recursive enumeration of the files with their
+	// sizes. The files are listed without CountFiles too.
+	Flat bool
+	// Print the size of each entry as a percentage of the size of the
+	// directory it is in instead, e.g. "45%". The roots are 100%.
+	Percent bool
+	// Write the paths in the Unicode normalization form C, so that the
+	// names a filesystem stores decomposed (NFD), like HFS+ does, compare
+	// equal to the same names elsewhere. Only the output is affected.
+	NormalizeUnicode bool
+	// Prefix the paths with Prefix, e.g. "/backup" turns "./sub" into
+	// "/backup/sub" and "/tmp/sub" into "/backup/tmp/sub", for the trees
+	// scanned in a staging directory or a chroot. Only the output is
+	// affected.
+	Prefix string
+	// If set, mark the directories with nothing in them modified since
+	// StaleBefore, not even the directories themselves, with [stale] after
+	// the path, as cold data that could be archived.
+	StaleBefore time.Time
+	// If set, the lines are formatted by Formatter instead of Format and
+	// the options that decorate the sizes, like Human or Color.
+	Formatter Formatter
+}
+
+// DefaultColorThresholds are used to colour the output when no other
+// thresholds are given: 1M and above is green, 100M and above is yellow and
+// 1G and above is red.
+var DefaultColorThresholds = []int64{1 << 20, 100 << 20, 1 << 30}
+
+// ANSI escape codes matching the colour thresholds.
+var colorCodes = []string{"\033[32m", "\033[33m", "\033[31m"}
+
+const colorReset = "\033[0m"
+
+// PrintDirTree walks over `dt` recursively and returns a slice of strings.
 // Each line in the slice is either a file or a directory and it's size
 // formatted according to `outFormat` string.
 // If `countFiles` is true files are printed first. If `summarise` is true
 // sub-directories are not printed out.
+//
+// Like GNU du -a, every file and directory is printed once and each directory
+// (even an empty one) comes after everything below it. Unlike GNU du, which
+// prints the entries of a directory in the order they are read, the files of
+// a directory come before its sub-directories and both are sorted by name.
 func (dt *DirTree) PrintDirTree(outFormat string, countFiles bool, summarise bool) []string {
+	return dt.Print(PrintOptions{
+		Format:     outFormat,
+		CountFiles: countFiles,
+		Summarise:  summarise,
+	})
+}
+
+// Print walks over `dt` recursively and returns a slice of strings, one for
+// each file or directory, formatted according to `opts`.
+func (dt *DirTree) Print(opts PrintOptions) []string {
+	entries := dt.entries(&opts)
+	// Human readable sizes have different lengths, find the longest one so
+	// that all of them can be aligned to it.
+	width := 0
+	if opts.Human {
+		for _, e := range entries {
+			if w := len(humanStyled(dt.bytes(e.size), opts.HumanStyle)); w > width {
+				width = w
+			}
+		}
+	}
+
+	return dt.lines(entries, width, &opts)
+}
+
+// PrintBreakdown returns a line with the total size of `dt` split into the
+// size of the files in it and the size of the directories themselves, for
+// example "files: 11376, dirs: 24, total: 11400\t./dir".
+func (dt *DirTree) PrintBreakdown(opts PrintOptions) string {
+	files := dt.sizeField(dt.size-dt.dirsSize, &opts)
+	dirs := dt.sizeField(dt.dirsSize, &opts)
+	total := dt.sizeField(dt.size, &opts)
+
+	return fmt.Sprintf("files: %d, dirs: %d, total: %d\t%s", files, dirs, total, opts.path(dt.dirEntry()))
+}
+
+// PrintTotal returns a line with the total size of `dt` in bytes and its
+// path separated by a space, for example "11661312 ./dir", whatever the
+// units and the format of the rest of the output are.
+func (dt *DirTree) PrintTotal(opts PrintOptions) string {
+	return fmt.Sprintf("%d %s", dt.bytes(dt.size), opts.path(dt.dirEntry()))
+}
+
+// PrintFiles returns the lines that Print prints for the files directly in
+// `dt`, if any. Together with PrintDir it allows printing a tree while it is
+// being scanned. Human readable sizes are not aligned in this case.
+func (dt *DirTree) PrintFiles(opts PrintOptions) []string {
+	return dt.lines(opts.filter(dt.fileEntries(&opts), dt), 0, &opts)
+}
+
+// PrintDir returns the line that Print prints for `dt` itself, if any, see
+// PrintFiles.
+func (dt *DirTree) PrintDir(opts PrintOptions) []string {
+	if !dt.visible(&opts) {
+		return nil
+	}
+	return dt.lines(opts.filter([]entry{dt.dirEntry()}, dt), 0, &opts)
+}
+
+// lines formats the `entries` according to `opts`, padding the sizes to
+// `width`.
+func (dt *DirTree) lines(entries []entry, width int, opts *PrintOptions) []string {
 	var out []string
-	// If "-a" is provided output files first
-	if countFiles {
-		for _, f := range dt.files {
-			out = append(out, fmt.Sprintf(outFormat, f.size, fixPath(f.path)))
+	for _, e := range entries {
+		sf := dt.sizeField(e.size, opts)
+		sf.width = width
+		sf.count = e.count
+		sf.parent = e.parent
+		sf.blocks = e.blocks
+		sf.children = e.children
+		path := opts.path(e)
+		if opts.Truncate > 0 {
+			path = truncatePath(path, opts.Truncate)
+		}
+		if opts.Mounts && e.mount {
+			path = path + mountMarker
 		}
+		if !opts.StaleBefore.IsZero() && !e.mtime.IsZero() && e.mtime.Before(opts.StaleBefore) {
+			path = path + staleMarker
+		}
+		if opts.Formatter != nil {
+			if e.depth == 0 {
+				out = append(out, opts.Formatter.FormatTotal(e.size, path))
+			} else {
+				out = append(out, opts.Formatter.FormatEntry(e.size, path, e.depth))
+			}
+			continue
+		}
+		out = append(out, fmt.Sprintf(opts.Format, sf, path))
+	}
+
+	return out
+}
+
+// The marker printed after the paths of mount points, see
+// PrintOptions.Mounts.
+const mountMarker = " [mount]"
+
+// The marker printed after the paths of the directories not modified
+// recently, see PrintOptions.StaleBefore.
+const staleMarker = " [stale]"
+
+// entry is a single file or directory in the output.
+type entry struct {
+	path    string
+	size    int64
+	count   int64 // number of files
+	depth   int
+	dir     bool
+	virtual bool
+	mount   bool
+	parent  int64     // size of the directory the entry is in, see Percent
+	mtime   time.Time // latest modification in a directory, see StaleBefore
+	blocks  int64     // st_blocks, see Blocks
+	// number of files and directories directly in a directory, see Children
+	children int64
+}
+
+// displayPath returns the path of `e` as it should be printed.
+func (e entry) displayPath() string {
+	if e.virtual {
+		return e.path
+	}
+	return fixPath(e.path)
+}
+
+// path returns the path of `e` as it should be printed according to `opts`.
+func (opts *PrintOptions) path(e entry) string {
+	path := e.displayPath()
+	if opts.NormalizeUnicode {
+		path = norm.NFC.String(path)
 	}
-	if !summarise {
-		for _, d := range dt.subdirs {
-			out = append(out, d.PrintDirTree(outFormat, countFiles, summarise)...)
+	if opts.Prefix != "" && !e.virtual {
+		path = filepath.Join(opts.Prefix, path)
+	}
+	if opts.Portable {
+		return filepath.ToSlash(path)
+	}
+	return path
+}
+
+// entries returns the files and directories of `dt` in the order they
+// should be printed according to `opts`.
+func (dt *DirTree) entries(opts *PrintOptions) []entry {
+	out := opts.filter(dt.walk(opts, dt.size), dt)
+	// Only the root total moves, sub-directories are still printed after
+	// their content. There is none to move if only the files are printed.
+	if n := len(out); opts.TotalAtTop && n > 0 && out[n-1].dir && out[n-1].depth == 0 {
+		out = append(out[n-1:], out[:n-1]...)
+	}
+
+	return out
+}
+
+// filter returns the `entries` of `dt` that are at least MinSize bytes big.
+func (opts *PrintOptions) filter(entries []entry, dt *DirTree) []entry {
+	if opts.MinSize <= 0 {
+		return entries
+	}
+	var big []entry
+	for _, e := range entries {
+		if e.depth == 0 || dt.bytes(e.size) >= opts.MinSize {
+			big = append(big, e)
 		}
 	}
-	out = append(out, fmt.Sprintf(outFormat, dt.size, fixPath(filepath.Clean(dt.path))))
+
+	return big
+}
+
+// walk collects the entries of `dt` recursively, files first, then
+// sub-directories and the directory itself last. The `parent` is the size
+// of the directory `dt` is in, or of `dt` itself for the root.
+func (dt *DirTree) walk(opts *PrintOptions, parent int64) []entry {
+	out := dt.fileEntries(opts)
+	if !opts.Summarise && opts.withinDepth(dt.depth+1) {
+		for _, d := range SortTrees(dt.subdirs, opts.Sort, opts.Reverse) {
+			out = append(out, d.walk(opts, dt.size)...)
+		}
+	}
+	if dt.visible(opts) {
+		e := dt.dirEntry()
+		e.parent = parent
+		out = append(out, e)
+	}
 
 	return out
 }
 
+// fileEntries returns the entries of the files directly in `dt` that should
+// be printed according to `opts`.
+func (dt *DirTree) fileEntries(opts *PrintOptions) []entry {
+	// If "-a" is provided output files first
+	if !opts.Flat && (!opts.CountFiles || !dt.visible(opts) || !opts.withinDepth(dt.depth+1)) {
+		return nil
+	}
+	var out []entry
+	for _, f := range sortFiles(dt.files, opts.Sort, opts.Reverse) {
+		out = append(out, entry{path: f.path, size: f.size, count: 1, depth: f.depth, parent: dt.size, blocks: f.blocks})
+	}
+
+	return out
+}
+
+// dirEntry returns the entry of `dt` itself.
+func (dt *DirTree) dirEntry() entry {
+	e := entry{path: filepath.Clean(dt.path), size: dt.size, count: dt.fileCount, depth: dt.depth, dir: true, virtual: dt.virtual, mount: dt.mount, blocks: dt.blocks, children: dt.children}
+	if !dt.file {
+		e.mtime = dt.mtime
+	}
+	return e
+}
+
+// visible reports whether `dt` itself is printed according to `opts`, the
+// root always is, but with Flat only the files are.
+func (dt *DirTree) visible(opts *PrintOptions) bool {
+	if opts.Flat {
+		return dt.file
+	}
+	if dt.depth == 0 {
+		return true
+	}
+	if opts.ByLeafDepth && dt.height() != opts.LeafDepth {
+		return false
+	}
+	if opts.SkipEmpty && dt.empty {
+		return false
+	}
+	return !opts.Summarise && opts.withinDepth(dt.depth)
+}
+
+// height returns the number of levels between `dt` and the deepest
+// directory under it, 0 if `dt` has no sub-directories.
+func (dt *DirTree) height() int {
+	h := 0
+	for _, d := range dt.subdirs {
+		if dh := d.height() + 1; dh > h {
+			h = dh
+		}
+	}
+
+	return h
+}
+
+// withinDepth reports whether the entries at `depth` should be printed
+// according to the MaxDepth.
+func (opts *PrintOptions) withinDepth(depth int) bool {
+	return opts.MaxDepth <= 0 || depth <= opts.MaxDepth
+}
+
+// sizeField is the size of an entry as it is passed to the output format.
+//
+// It implements fmt.Formatter so that the size can be decorated (coloured
+// for example) while the output format still uses the "%d" verb for it.
+type sizeField struct {
+	size  int64 // size in units
+	bytes int64 // size in bytes, used to pick a colour
+	width int   // minimal width, shorter sizes are padded with spaces
+	count int64 // number of files, printed with Counts
+	// st_blocks of the entry, for Blocks
+	blocks int64
+	// number of entries directly in a directory, for Children
+	children int64
+	// size of the directory the entry is in, in units, for Percent
+	parent int64
+	opts   *PrintOptions
+}
+
+// sizeField returns a formattable size of an entry in units.
+func (dt *DirTree) sizeField(size int64, opts *PrintOptions) sizeField {
+	return sizeField{size: size, bytes: dt.bytes(size), opts: opts}
+}
+
+// Format implements fmt.Formatter.
+func (s sizeField) Format(f fmt.State, verb rune) {
+	txt := strconv.FormatInt(s.size, 10)
+	if s.opts.Percent {
+		txt = percentOf(s.size, s.parent)
+	} else if s.opts.Human {
+		txt = humanStyled(s.bytes, s.opts.HumanStyle)
+	} else if len(txt) < s.opts.PadWidth {
+		pad := " "
+		if s.opts.ZeroPad {
+			pad = "0"
+		}
+		txt = strings.Repeat(pad, s.opts.PadWidth-len(txt)) + txt
+	}
+	if len(txt) < s.width {
+		txt = strings.Repeat(" ", s.width-len(txt)) + txt
+	}
+	if s.opts.Human && s.opts.Bytes {
+		txt = strconv.FormatInt(s.bytes, 10) + "\t" + txt
+	}
+	if s.opts.Color {
+		txt = colorize(txt, s.bytes, s.opts.ColorThresholds)
+	}
+	if s.opts.Blocks {
+		txt = txt + "\t" + strconv.FormatInt(s.blocks, 10)
+	}
+	if s.opts.Counts {
+		txt = txt + "\t" + strconv.FormatInt(s.count, 10)
+	}
+	if s.opts.Children {
+		txt = txt + "\t" + strconv.FormatInt(s.children, 10) + " items"
+	}
+	fmt.Fprint(f, txt)
+}
+
+// percentOf returns `size` as a whole percentage of `parent`, e.g. "45%",
+// rounded to the nearest. It is "0%" if `parent` is 0.
+func percentOf(size, parent int64) string {
+	if parent == 0 {
+		return "0%"
+	}
+	return strconv.FormatInt(int64(math.Round(100*float64(size)/float64(parent))), 10) + "%"
+}
+
+// colorize wraps `txt` in the ANSI colour matching the highest threshold
+// that `bytes` reaches. If none is reached `txt` is returned as is.
+func colorize(txt string, bytes int64, thresholds []int64) string {
+	if thresholds == nil {
+		thresholds = DefaultColorThresholds
+	}
+	code := ""
+	for i, t := range thresholds {
+		if i < len(colorCodes) && bytes >= t {
+			code = colorCodes[i]
+		}
+	}
+	if code == "" {
+		return txt
+	}
+	return code + txt + colorReset
+}
+
+// humanSize returns `bytes` in a human readable format using powers of
+// 1024, for example 1.5K, 234M or 2.0G. Like in GNU du, sizes are rounded up
+// and the ones smaller than 10 have one decimal digit.
+//
+// The rounding follows human_readable() of coreutils: the integer part, the
+// tenths and whether anything is left below the tenths are kept separately
+// while dividing, so that the result is exact even for the sizes that don't
+// fit in a float64.
+func humanSize(bytes int64) string {
+	const suffixes = "KMGTPE"
+	if bytes < 1024 {
+		return strconv.FormatInt(bytes, 10)
+	}
+	amount, tenths, rest := bytes, int64(0), false
+	i := -1
+	for amount >= 1024 && i < len(suffixes)-1 {
+		r10 := amount%1024*10 + tenths
+		rest = rest || r10%1024 != 0
+		amount, tenths = amount/1024, r10/1024
+		i++
+	}
+	if amount < 10 {
+		if rest {
+			tenths, rest = tenths+1, false
+			if tenths == 10 {
+				amount, tenths = amount+1, 0
+			}
+		}
+		if amount < 10 {
+			return fmt.Sprintf("%d.%d%c", amount, tenths, suffixes[i])
+		}
+	}
+	if tenths > 0 || rest {
+		amount++
+	}
+	// Rounding up can get us to the next suffix, e.g. 1023.5K is 1.0M
+	if amount == 1024 && i < len(suffixes)-1 {
+		return fmt.Sprintf("1.0%c", suffixes[i+1])
+	}
+
+	return fmt.Sprintf("%d%c", amount, suffixes[i])
+}
+
+// HumanStyle tells how the suffixes of the human readable sizes are written.
+type HumanStyle int
+
+const (
+	// HumanShort writes just the letter of the unit, e.g. 4.0K, like GNU du.
+	HumanShort HumanStyle = iota
+	// HumanIEC writes the IEC binary prefix of the unit, e.g. 4.0KiB.
+	HumanIEC
+	// HumanSpace separates the letter of the unit with a space, e.g. 4.0 K.
+	HumanSpace
+)
+
+// humanStyled returns humanSize(bytes) with the suffix written in `style`.
+// The sizes under 1K have no suffix in any style.
+func humanStyled(bytes int64, style HumanStyle) string {
+	txt := humanSize(bytes)
+	if bytes < 1024 {
+		return txt
+	}
+	amount, unit := txt[:len(txt)-1], txt[len(txt)-1:]
+	switch style {
+	case HumanIEC:
+		return amount + unit + "iB"
+	case HumanSpace:
+		return amount + " " + unit
+	}
+
+	return txt
+}
+
+// Special files are devices, named pipes and sockets.
+const modeSpecial = os.ModeDevice | os.ModeCharDevice | os.ModeNamedPipe | os.ModeSocket
+
+// countableSize returns the space allocated to the file described by `info`
+// in units. This is the single place that decides what each kind of file
+// counts for:
+//
+//   - A named pipe or a socket takes no space, whatever passes through it
+//     is kept in memory, so it counts for 0.
+//   - The size of a device is the size of the device itself rather than its
+//     disk usage, so the blocks allocated to the device node are counted
+//     instead. That is usually 0.
+//   - Anything else, a regular file, a directory or a symbolic link, counts
+//     for the space allocated to it, see calcSize. Except for a regular file
+//     smaller than a block with Scanner.TailPacking, which counts for its
+//     apparent size.
+//
+// With the apparent sizes a device counts for its st_size as well, and with
+// the exact allocated sizes any file but a pipe or a socket counts for what
+// the filesystem reports, see Scanner.ExactAllocated.
+func (dt *DirTree) countableSize(info os.FileInfo) int64 {
+	mode := info.Mode()
+	switch {
+	case mode&(os.ModeNamedPipe|os.ModeSocket) != 0:
+		return 0
+	case dt.exact:
+		return dt.toUnits(dt.allocated(info))
+	case dt.tailPacking && mode.IsRegular() && info.Size() < dt.blockSize:
+		return dt.toUnits(info.Size())
+	case mode&os.ModeDevice != 0 && !dt.apparent:
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok || st.Blocks == 0 {
+			return 0
+		}
+		// st_blocks is always in 512-byte blocks
+		return dt.toUnits(int64(st.Blocks) * 512)
+	default:
+		return dt.calcSize(info.Size())
+	}
+}
+
+// stBlocks returns the number of 512-byte blocks allocated to the file
+// described by `info` (st_blocks), or 0 if the system doesn't tell.
+func stBlocks(info os.FileInfo) int64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Blocks)
+	}
+	return 0
+}
+
 // calcSize receives size in bytes and returns size in units.
 //
 // Filesystem allocates space in blocks and not in bytes. That is why the
 // actual size of the file is usually smaller than the space allocated for
 // it by the system. Since we want to report the acual space in use and
 // not the file size we need calculate the number of filesystem blocks
-// allocated to the file.
+// allocated to the file. Unless the apparent sizes are asked for, then the
+// size is used as is and nothing is added for the blocks.
 func (dt *DirTree) calcSize(size int64) int64 {
 	if size == 0 {
 		return 0
 	}
+	if dt.apparent {
+		return dt.toUnits(size)
+	}
 	allocSize := (1 + (size-1)/dt.blockSize) * dt.blockSize
-	return 1 + (allocSize-1)/dt.unitSize
+	return dt.toUnits(allocSize)
 }
 
-// Get filesystem block size.
-//
-// Returns block size in bytes or error.
-// https://man7.org/linux/man-pages/man2/statfs.2.html
-func getFSBlockSize(path string) (int64, error) {
-	var stat syscall.Statfs_t
-	if err := syscall.Statfs(path, &stat); err != nil {
-		return 0, err
-	} else {
-		return int64(stat.Bsize), nil
+// toUnits converts `bytes` to units according to the rounding mode. Unlike
+// the units, the blocks allocated by the filesystem are always whole.
+func (dt *DirTree) toUnits(bytes int64) int64 {
+	if bytes == 0 {
+		return 0
+	}
+	switch dt.rounding {
+	case RoundDown:
+		return bytes / dt.unitSize
+	case RoundNearest:
+		return (bytes + dt.unitSize/2) / dt.unitSize
+	default:
+		return 1 + (bytes-1)/dt.unitSize
 	}
 }
 
+// truncatePath shortens `path` to at most `n` characters by replacing the
+// directories in the middle of it with "...", for example "a/b/.../y/z". As
+// many directories as possible are kept from both ends. If that is not
+// enough, the characters in the middle are replaced instead.
+func truncatePath(path string, n int) string {
+	if utf8.RuneCountInString(path) <= n {
+		return path
+	}
+	parts := strings.Split(path, "/")
+	length := func(head, tail int) int {
+		return utf8.RuneCountInString(strings.Join(parts[:head], "/") + "/.../" + strings.Join(parts[len(parts)-tail:], "/"))
+	}
+	if len(parts) > 2 && length(1, 1) <= n {
+		head, tail := 1, 1
+		// Keep at least one directory out, and the rest alternately from
+		// the end and from the beginning while they fit
+		for head+tail < len(parts)-1 {
+			if tail <= head && length(head, tail+1) <= n {
+				tail++
+			} else if length(head+1, tail) <= n {
+				head++
+			} else if length(head, tail+1) <= n {
+				tail++
+			} else {
+				break
+			}
+		}
+		return strings.Join(parts[:head], "/") + "/.../" + strings.Join(parts[len(parts)-tail:], "/")
+	}
+	r := []rune(path)
+	if n <= 3 {
+		return string(r[len(r)-n:])
+	}
+	head := (n - 3) / 2
+	return string(r[:head]) + "..." + string(r[len(r)-(n-3-head):])
+}
+
 // fixPath adds './' to the begining of the relative paths.
+//
+// The paths that are already relative to the current or the parent
+// directory are left as they are, but not the hidden files in the current
+// one: "." joined with ".config" is ".config", which GNU du prints as
+// "./.config".
 func fixPath(path string) string {
-	if filepath.IsAbs(path) {
+	if path == "" || filepath.IsAbs(path) {
 		return path
 	}
-	if path[0] == '.' {
+	if path == "." || path == ".." || strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
 		return path
 	}
 	return "./" + path
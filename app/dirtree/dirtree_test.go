@@ -1,10 +1,18 @@
 package dirtree
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 // The below is needed because "packages that call flag.Parse during package
@@ -257,6 +265,27 @@ func createTestData(testFiles []testFile) error {
 	return nil
 }
 
+// generateTree creates a synthetic tree under `dir`: every directory in it
+// has `width` files of 1000 bytes and, up to `depth` levels below `dir`,
+// `width` sub-directories.
+func generateTree(dir string, width, depth int) error {
+	for i := 0; i < width; i++ {
+		if err := createDummyFile(filepath.Join(dir, "file"+strconv.Itoa(i)+".txt"), 1000); err != nil {
+			return err
+		}
+	}
+	if depth == 0 {
+		return nil
+	}
+	for i := 0; i < width; i++ {
+		if err := generateTree(filepath.Join(dir, "dir"+strconv.Itoa(i)), width, depth-1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Delete test directories and files.
 func resetTestData() error {
 	if err := os.RemoveAll(testFilesRoot); err != nil {
@@ -293,3 +322,1146 @@ func Test_BuildTree(t *testing.T) {
 		})
 	}
 }
+
+func Test_Rounding(t *testing.T) {
+	var tests = []struct {
+		name string
+		mode RoundingMode
+		size int64 // in bytes
+		want int64 // in units of 1000 bytes
+	}{
+		// 8192 bytes allocated
+		{"Up", RoundUp, 5678, 9},
+		{"Down", RoundDown, 5678, 8},
+		{"Nearest down", RoundNearest, 5678, 8},
+		// 24576 bytes allocated
+		{"Nearest up", RoundNearest, 23456, 25},
+		{"Empty", RoundUp, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dt := &DirTree{unitSize: 1000, blockSize: 4096, rounding: tt.mode}
+			if got := dt.calcSize(tt.size); got != tt.want {
+				t.Errorf("Expecting %d bytes to be %d units and not %d", tt.size, tt.want, got)
+			}
+		})
+	}
+
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	s := NewScanner(1 << 20)
+	s.Rounding = RoundDown
+	dt, _ := s.Scan(testFilesRoot)
+	if dt.size != 0 || dt.subdirs[0].rounding != RoundDown {
+		t.Errorf("Expecting the rounding mode to apply to the whole tree, got size %d", dt.size)
+	}
+}
+
+func Test_PrintColor(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	out := dt.Print(PrintOptions{Format: "%d\t%s", CountFiles: true, Color: true})
+	want := []string{
+		"8\t" + testFilesRoot + "/under_4k.txt",
+		"\033[32m11360\033[0m\t" + testFilesRoot + "/subdir/over_4m.txt",
+		"\033[32m11368\033[0m\t" + testFilesRoot + "/subdir",
+		"\033[32m11384\033[0m\t" + testFilesRoot,
+	}
+	if len(out) != len(want) {
+		t.Fatalf("Expecting %d lines and not %d: %q", len(want), len(out), out)
+	}
+	for i, v := range out {
+		if v != want[i] {
+			t.Errorf("Output string #%v %q is not equal to the expected one %q", i, v, want[i])
+		}
+	}
+
+	// Custom thresholds, everything from 1M is red
+	out = dt.Print(PrintOptions{Format: "%d\t%s", Summarise: true, Color: true, ColorThresholds: []int64{0, 0, 1 << 20}})
+	if want := "\033[31m11384\033[0m\t" + testFilesRoot; out[0] != want {
+		t.Errorf("Output string %q is not equal to the expected one %q", out[0], want)
+	}
+}
+
+func Test_PrintTotalAtTop(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	out := dt.Print(PrintOptions{Format: "%d\t%s", CountFiles: true, TotalAtTop: true})
+	want := []string{
+		"11384\t" + testFilesRoot,
+		"8\t" + testFilesRoot + "/under_4k.txt",
+		"11360\t" + testFilesRoot + "/subdir/over_4m.txt",
+		"11368\t" + testFilesRoot + "/subdir",
+	}
+	if len(out) != len(want) {
+		t.Fatalf("Expecting %d lines and not %d: %q", len(want), len(out), out)
+	}
+	for i, v := range out {
+		if v != want[i] {
+			t.Errorf("Output string #%v %q is not equal to the expected one %q", i, v, want[i])
+		}
+	}
+}
+
+func Test_HumanSize(t *testing.T) {
+	var tests = []struct {
+		bytes int64
+		want  string
+	}{
+		{0, "0"},
+		{1023, "1023"},
+		{1024, "1.0K"},
+		{1025, "1.1K"},
+		{4096, "4.0K"},
+		{1024*1024 - 1, "1.0M"},
+		{11630592, "12M"},
+		{5 << 30, "5.0G"},
+		{10137, "9.9K"},
+		{10138, "10K"},
+		{10 * 1024, "10K"},
+		{10*1024 + 1, "11K"},
+		{1023 * 1024, "1023K"},
+		{1023*1024 + 1, "1.0M"},
+		{1<<62 + 1, "4.1E"},
+		{1<<63 - 1, "8.0E"},
+	}
+	for _, tt := range tests {
+		if got := humanSize(tt.bytes); got != tt.want {
+			t.Errorf("Expecting %d bytes to be %q and not %q", tt.bytes, tt.want, got)
+		}
+	}
+}
+
+func Test_HumanStyled(t *testing.T) {
+	var tests = []struct {
+		style HumanStyle
+		bytes int64
+		want  string
+	}{
+		{HumanShort, 4096, "4.0K"},
+		{HumanIEC, 4096, "4.0KiB"},
+		{HumanSpace, 4096, "4.0 K"},
+		{HumanIEC, 11630592, "12MiB"},
+		{HumanSpace, 11630592, "12 M"},
+		{HumanIEC, 512, "512"},
+		{HumanSpace, 512, "512"},
+	}
+	for _, tt := range tests {
+		if got := humanStyled(tt.bytes, tt.style); got != tt.want {
+			t.Errorf("Expecting %d bytes to be %q in style %d and not %q", tt.bytes, tt.want, tt.style, got)
+		}
+	}
+}
+
+func Test_PrintHuman(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "10k.txt"), 10 * 1024},
+		{filepath.Join(testFilesRoot, "empty.txt"), 0},
+		{filepath.Join(testFilesRoot, "subdir", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	out := dt.Print(PrintOptions{Format: "%d\t%s", CountFiles: true, Human: true})
+	want := []string{
+		" 12K\t" + testFilesRoot + "/10k.txt",
+		"   0\t" + testFilesRoot + "/empty.txt",
+		"5.6M\t" + testFilesRoot + "/subdir/over_4m.txt",
+		"5.6M\t" + testFilesRoot + "/subdir",
+		"5.6M\t" + testFilesRoot,
+	}
+	if len(out) != len(want) {
+		t.Fatalf("Expecting %d lines and not %d: %q", len(want), len(out), out)
+	}
+	for i, v := range out {
+		if v != want[i] {
+			t.Errorf("Output string #%v %q is not equal to the expected one %q", i, v, want[i])
+		}
+	}
+}
+
+func Test_PrintBytesAndHuman(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "10k.txt"), 10 * 1024},
+		{filepath.Join(testFilesRoot, "subdir", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	out := dt.Print(PrintOptions{Format: "%d\t%s", Human: true, Bytes: true})
+	want := []string{
+		"5820416\t5.6M\t" + testFilesRoot + "/subdir",
+		"5836800\t5.6M\t" + testFilesRoot,
+	}
+	if strings.Join(out, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Expecting output %q and not %q", want, out)
+	}
+	// Both columns describe the same size
+	for _, line := range out {
+		fields := strings.Split(line, "\t")
+		bytes, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if h := humanSize(bytes); h != fields[1] {
+			t.Errorf("Expecting the human readable size of %d to be %s and not %s", bytes, h, fields[1])
+		}
+	}
+}
+
+func Test_PrintCounts(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "a", "empty.txt"), 0},
+		{filepath.Join(testFilesRoot, "a", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "a", "b", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	out := dt.Print(PrintOptions{Format: "%d\t%s", Counts: true})
+	want := []string{
+		"24\t1\t" + testFilesRoot + "/a/b",
+		"48\t3\t" + testFilesRoot + "/a",
+		"64\t4\t" + testFilesRoot,
+	}
+	if strings.Join(out, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, out)
+	}
+}
+
+func Test_PrintPadded(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "a", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	var tests = []struct {
+		name string
+		opts PrintOptions
+		want []string
+	}{
+		{"spaces", PrintOptions{PadWidth: 6}, []string{
+			"    24\t" + testFilesRoot + "/a",
+			"    40\t" + testFilesRoot,
+		}},
+		{"zeros", PrintOptions{PadWidth: 6, ZeroPad: true}, []string{
+			"000024\t" + testFilesRoot + "/a",
+			"000040\t" + testFilesRoot,
+		}},
+		{"shorter than the size", PrintOptions{PadWidth: 1, ZeroPad: true}, []string{
+			"24\t" + testFilesRoot + "/a",
+			"40\t" + testFilesRoot,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Format = "%d\t%s"
+			if got := dt.Print(tt.opts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expecting output %q and not %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_PrintPortable(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "a", "b", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	// The paths use '/' on any operating system
+	root := filepath.ToSlash(testFilesRoot)
+	dt := New(testFilesRoot, 512)
+	got := dt.Print(PrintOptions{Format: "%[2]s", CountFiles: true, Portable: true})
+	want := []string{
+		root + "/a/b/over_4k.txt",
+		root + "/a/b",
+		root + "/a",
+		root,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting %q and not %q", want, got)
+	}
+	lines := dt.PrintJSONLines(PrintOptions{CountFiles: true, Portable: true})
+	if len(lines) != len(want) || !strings.Contains(lines[0], `"path":"`+want[0]+`"`) {
+		t.Errorf("Expecting the JSON paths to be %q and not %q", want, lines)
+	}
+}
+
+func Test_FixPath(t *testing.T) {
+	var tests = []struct {
+		path string
+		want string
+	}{
+		{".", "."},
+		{"..", ".."},
+		{"a", "./a"},
+		{"a/b", "./a/b"},
+		{"./a", "./a"},
+		{"../a", "../a"},
+		{".config", "./.config"},
+		{"..config", "./..config"},
+		{"/a", "/a"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := fixPath(tt.path); got != tt.want {
+			t.Errorf("Expecting %q to be written as %q and not %q", tt.path, tt.want, got)
+		}
+	}
+}
+
+func Test_TruncatePath(t *testing.T) {
+	var tests = []struct {
+		path string
+		n    int
+		want string
+	}{
+		{"./a/b/c", 10, "./a/b/c"},
+		{"./aaaa/bbbb/cccc/dddd/eeee", 14, "./.../eeee"},
+		{"./aaaa/bbbb/cccc/dddd/eeee", 19, "./.../dddd/eeee"},
+		{"./aaaa/bbbb/cccc/dddd/eeee", 20, "./aaaa/.../dddd/eeee"},
+		{"/aaaa/bbbb/cccc", 12, "/.../cccc"},
+		{"./a_very_long_file_name.txt", 12, "./a_...e.txt"},
+		{"./a/a_very_long_file_name.txt", 12, "./a/...e.txt"},
+		{"./a_very_long_file_name.txt", 3, "txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := truncatePath(tt.path, tt.n); got != tt.want {
+				t.Errorf("Expecting %s to be shortened to %s and not %s", tt.path, tt.want, got)
+			}
+		})
+	}
+
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "subdir", "another_subdir", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	out := New(testFilesRoot, 512).Print(PrintOptions{Format: "%d\t%s", CountFiles: true, Truncate: 30})
+	if want := "16\t./testdata/.../over_4k.txt"; out[0] != want {
+		t.Errorf("Expecting output %q and not %q", want, out[0])
+	}
+}
+
+func Test_PrintBreakdown(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4m.txt"), 5678 * 1024},
+		{filepath.Join(testFilesRoot, "subdir", "empty", "empty.txt"), 0},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	got := dt.PrintBreakdown(PrintOptions{})
+	// Two files with data and three directories add up to the total
+	want := "files: 11368, dirs: 24, total: 11392\t" + testFilesRoot
+	if got != want {
+		t.Errorf("Expecting %q and not %q", want, got)
+	}
+}
+
+func Test_PrintTotal(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	for _, unitSize := range []int64{512, 1024} {
+		dt := New(testFilesRoot, unitSize)
+		want := strconv.FormatInt(dt.size*unitSize, 10) + " " + testFilesRoot
+		if got := dt.PrintTotal(PrintOptions{Human: true}); got != want {
+			t.Errorf("Expecting the total %q and not %q", want, got)
+		}
+	}
+}
+
+func Test_SpecialFiles(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	if err := syscall.Mkfifo(filepath.Join(testFilesRoot, "fifo"), 0644); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	dt := New(testFilesRoot, 512)
+	if want := dt.calcSize(4096 + 4096); dt.size != want {
+		t.Errorf("Expecting size to be %v and not %v", want, dt.size)
+	}
+	out := dt.Print(PrintOptions{Format: "%d\t%s", CountFiles: true})
+	if want := "0\t" + testFilesRoot + "/fifo"; out[0] != want {
+		t.Errorf("Output string %q is not equal to the expected one %q", out[0], want)
+	}
+}
+
+func Test_SpecialFileOperand(t *testing.T) {
+	if err := createTestData([]testFile{{filepath.Join(testFilesRoot, "under_4k.txt"), 3456}}); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	fifo := filepath.Join(testFilesRoot, "fifo")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	var buf bytes.Buffer
+	errLog.SetOutput(&buf)
+	defer errLog.SetOutput(os.Stderr)
+
+	dt, err := NewScanner(512).Scan(fifo)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if dt.size != 0 {
+		t.Errorf("Expecting a FIFO to take 0 units and not %d", dt.size)
+	}
+	if !strings.Contains(buf.String(), "'"+fifo+"' is a device or a special file") {
+		t.Errorf("Expecting a note about the special file and not %q", buf.String())
+	}
+}
+
+// modeInfo is a file of the kind `mode` that is `size` bytes long and has
+// `blocks` 512-byte blocks allocated to it.
+type modeInfo struct {
+	os.FileInfo
+	mode   os.FileMode
+	size   int64
+	blocks int64
+}
+
+func (i modeInfo) Mode() os.FileMode { return i.mode }
+func (i modeInfo) Size() int64       { return i.size }
+func (i modeInfo) Sys() interface{}  { return &syscall.Stat_t{Size: i.size, Blocks: i.blocks} }
+
+func Test_CountableSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		info     modeInfo
+		want     int64
+		apparent int64
+		exact    int64
+	}{
+		{"Regular file", modeInfo{size: 3456, blocks: 8}, 8, 7, 8},
+		{"Sparse file", modeInfo{size: 5678, blocks: 0}, 16, 12, 0},
+		{"Directory", modeInfo{mode: os.ModeDir, size: 4096, blocks: 8}, 8, 8, 8},
+		{"Symbolic link", modeInfo{mode: os.ModeSymlink, size: 12}, 8, 1, 0},
+		{"Named pipe", modeInfo{mode: os.ModeNamedPipe, size: 4096, blocks: 8}, 0, 0, 0},
+		{"Socket", modeInfo{mode: os.ModeSocket, size: 4096, blocks: 8}, 0, 0, 0},
+		{"Block device", modeInfo{mode: os.ModeDevice, size: 1 << 30}, 0, 1 << 21, 0},
+		{"Block device with blocks", modeInfo{mode: os.ModeDevice, size: 1 << 30, blocks: 8}, 8, 1 << 21, 8},
+		{"Character device", modeInfo{mode: os.ModeDevice | os.ModeCharDevice, size: 1 << 30}, 0, 1 << 21, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dt := &DirTree{unitSize: 512, blockSize: 4096}
+			if got := dt.countableSize(tt.info); got != tt.want {
+				t.Errorf("Expecting %d units and not %d", tt.want, got)
+			}
+			dt.apparent = true
+			if got := dt.countableSize(tt.info); got != tt.apparent {
+				t.Errorf("Expecting %d units of apparent size and not %d", tt.apparent, got)
+			}
+			dt.apparent, dt.exact = false, true
+			if got := dt.countableSize(tt.info); got != tt.exact {
+				t.Errorf("Expecting %d units of exact size and not %d", tt.exact, got)
+			}
+		})
+	}
+}
+
+func Test_PrintMinSize(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4m.txt"), 5678 * 1024},
+		{filepath.Join(testFilesRoot, "small", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	var tests = []struct {
+		name   string
+		size   int64
+		output []string
+	}{
+		{"Everything", 0, []string{
+			"8\t" + testFilesRoot + "/under_4k.txt",
+			"16\t" + testFilesRoot + "/small/over_4k.txt",
+			"24\t" + testFilesRoot + "/small",
+			"11360\t" + testFilesRoot + "/subdir/over_4m.txt",
+			"11368\t" + testFilesRoot + "/subdir",
+			"11408\t" + testFilesRoot,
+		}},
+		{"Exactly the size", 24 * 512, []string{
+			"24\t" + testFilesRoot + "/small",
+			"11360\t" + testFilesRoot + "/subdir/over_4m.txt",
+			"11368\t" + testFilesRoot + "/subdir",
+			"11408\t" + testFilesRoot,
+		}},
+		{"Only big", 1 << 20, []string{
+			"11360\t" + testFilesRoot + "/subdir/over_4m.txt",
+			"11368\t" + testFilesRoot + "/subdir",
+			"11408\t" + testFilesRoot,
+		}},
+		{"Root is always there", 1 << 30, []string{
+			"11408\t" + testFilesRoot,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := dt.Print(PrintOptions{Format: "%d\t%s", CountFiles: true, MinSize: tt.size})
+			if len(out) != len(tt.output) {
+				t.Fatalf("Expecting %d lines and not %d: %q", len(tt.output), len(out), out)
+			}
+			for i, v := range out {
+				if v != tt.output[i] {
+					t.Errorf("Output string #%v %q is not equal to the expected one %q", i, v, tt.output[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_Combine(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "a", "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "b", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	a := New(filepath.Join(testFilesRoot, "a"), 512)
+	b := New(filepath.Join(testFilesRoot, "b"), 512)
+	out := Combine("total", a, b).Print(PrintOptions{Format: "%d\t%s"})
+	want := []string{
+		"16\t" + testFilesRoot + "/a",
+		"24\t" + testFilesRoot + "/b",
+		"40\ttotal",
+	}
+	if len(out) != len(want) {
+		t.Fatalf("Expecting %d lines and not %d: %q", len(want), len(out), out)
+	}
+	for i, v := range out {
+		if v != want[i] {
+			t.Errorf("Output string #%v %q is not equal to the expected one %q", i, v, want[i])
+		}
+	}
+}
+
+func Test_PrintMaxDepth(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "subdir", "nested", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	var tests = []struct {
+		name   string
+		opts   PrintOptions
+		output []string
+	}{
+		{"-a -d 1", PrintOptions{CountFiles: true, MaxDepth: 1}, []string{
+			"8\t" + testFilesRoot + "/under_4k.txt",
+			"11392\t" + testFilesRoot + "/subdir",
+			"11408\t" + testFilesRoot,
+		}},
+		{"-a -d 2", PrintOptions{CountFiles: true, MaxDepth: 2}, []string{
+			"8\t" + testFilesRoot + "/under_4k.txt",
+			"16\t" + testFilesRoot + "/subdir/over_4k.txt",
+			"11368\t" + testFilesRoot + "/subdir/nested",
+			"11392\t" + testFilesRoot + "/subdir",
+			"11408\t" + testFilesRoot,
+		}},
+		{"-d 1", PrintOptions{MaxDepth: 1}, []string{
+			"11392\t" + testFilesRoot + "/subdir",
+			"11408\t" + testFilesRoot,
+		}},
+		{"No limit", PrintOptions{}, []string{
+			"11368\t" + testFilesRoot + "/subdir/nested",
+			"11392\t" + testFilesRoot + "/subdir",
+			"11408\t" + testFilesRoot,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Format = "%d\t%s"
+			out := dt.Print(tt.opts)
+			if len(out) != len(tt.output) {
+				t.Fatalf("Expecting %d lines and not %d: %q", len(tt.output), len(out), out)
+			}
+			for i, v := range out {
+				if v != tt.output[i] {
+					t.Errorf("Output string #%v %q is not equal to the expected one %q", i, v, tt.output[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_PrintLeafDepth(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "one", "two", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "leaf", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	var tests = []struct {
+		name   string
+		opts   PrintOptions
+		output []string
+	}{
+		{"--leaf-depth=0", PrintOptions{ByLeafDepth: true}, []string{
+			testFilesRoot + "/leaf",
+			testFilesRoot + "/one/two",
+			testFilesRoot,
+		}},
+		{"--leaf-depth=1", PrintOptions{ByLeafDepth: true, LeafDepth: 1}, []string{
+			testFilesRoot + "/one",
+			testFilesRoot,
+		}},
+		{"-a --leaf-depth=0", PrintOptions{CountFiles: true, ByLeafDepth: true}, []string{
+			testFilesRoot + "/under_4k.txt",
+			testFilesRoot + "/leaf/over_4k.txt",
+			testFilesRoot + "/leaf",
+			testFilesRoot + "/one/two/over_4k.txt",
+			testFilesRoot + "/one/two",
+			testFilesRoot,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Format = "%d\t%s"
+			var paths []string
+			for _, l := range dt.Print(tt.opts) {
+				paths = append(paths, l[strings.Index(l, "\t")+1:])
+			}
+			if !reflect.DeepEqual(paths, tt.output) {
+				t.Errorf("Expecting %q and not %q", tt.output, paths)
+			}
+		})
+	}
+}
+
+// Test_PrintAllParity checks the output of -a against the rules of GNU du:
+// every file and directory is printed once, including the empty directories,
+// each directory after everything below it and the root last.
+func Test_PrintAllParity(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "z.txt"), 3456},
+		{filepath.Join(testFilesRoot, "a", "f1.txt"), 3456},
+		{filepath.Join(testFilesRoot, "b", "g.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	if err := os.MkdirAll(filepath.Join(testFilesRoot, "a", "empty"), 0755); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	dt := New(testFilesRoot, 512)
+	var paths []string
+	for _, l := range dt.PrintDirTree("%d\t%s", true, false) {
+		paths = append(paths, l[strings.Index(l, "\t")+1:])
+	}
+	want := []string{
+		testFilesRoot + "/z.txt",
+		testFilesRoot + "/a/f1.txt",
+		testFilesRoot + "/a/empty",
+		testFilesRoot + "/a",
+		testFilesRoot + "/b/g.txt",
+		testFilesRoot + "/b",
+		testFilesRoot,
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Expecting %q and not %q", want, paths)
+	}
+	seen := make(map[string]int)
+	for i, p := range paths {
+		if _, ok := seen[p]; ok {
+			t.Errorf("Expecting %q to be printed once", p)
+		}
+		seen[p] = i
+	}
+	for p, i := range seen {
+		for q, j := range seen {
+			if strings.HasPrefix(q, p+"/") && j > i {
+				t.Errorf("Expecting %q to be printed before its directory %q", q, p)
+			}
+		}
+	}
+}
+
+func Test_PrintSkipEmpty(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "full", "sub", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	for _, dir := range []string{"empty/a/b", "full/empty", "links"} {
+		if err := os.MkdirAll(filepath.Join(testFilesRoot, dir), 0755); err != nil {
+			t.Fatalf("Failed to create test data: %v", err)
+		}
+	}
+	if err := os.Symlink("../under_4k.txt", filepath.Join(testFilesRoot, "links", "link.txt")); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	dt := New(testFilesRoot, 512)
+	paths := dt.Print(PrintOptions{Format: "%[2]s", SkipEmpty: true})
+	want := []string{
+		testFilesRoot + "/full/sub",
+		testFilesRoot + "/full",
+		testFilesRoot,
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Expecting %q and not %q", want, paths)
+	}
+}
+
+func Test_PrintFlat(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "subdir", "deeper", "exactly_4k.txt"), 4096},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	opts := PrintOptions{Format: "%d\t%s", Flat: true}
+	want := []string{
+		"8\t" + testFilesRoot + "/under_4k.txt",
+		"16\t" + testFilesRoot + "/subdir/over_4k.txt",
+		"8\t" + testFilesRoot + "/subdir/deeper/exactly_4k.txt",
+	}
+	if got := New(testFilesRoot, 512).Print(opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting only the files %q and not %q", want, got)
+	}
+	// A file is listed itself
+	file := filepath.Join(testFilesRoot, "under_4k.txt")
+	if got := New(file, 512).Print(opts); !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("Expecting the file %q and not %q", want[:1], got)
+	}
+	// There is no total to move to the top
+	opts.TotalAtTop = true
+	if got := New(testFilesRoot, 512).Print(opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting the files %q in order with TotalAtTop and not %q", want, got)
+	}
+	empty := filepath.Join(testFilesRoot, "empty")
+	if err := os.Mkdir(empty, 0755); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	if got := New(empty, 512).Print(opts); len(got) != 0 {
+		t.Errorf("Expecting nothing for an empty directory and not %q", got)
+	}
+}
+
+func Test_PrintPercent(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	got := dt.Print(PrintOptions{Format: "%d\t%s", CountFiles: true, Percent: true})
+	want := []string{
+		"20%\t" + testFilesRoot + "/under_4k.txt",
+		"67%\t" + testFilesRoot + "/subdir/over_4k.txt",
+		"60%\t" + testFilesRoot + "/subdir",
+		"100%\t" + testFilesRoot,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting %q and not %q", want, got)
+	}
+	// The content of a directory and the directory itself make up all of it
+	sum := 0.0
+	for _, e := range dt.walk(&PrintOptions{CountFiles: true}, dt.size) {
+		if e.depth == 1 {
+			sum = sum + 100*float64(e.size)/float64(e.parent)
+		}
+	}
+	if own := 100 * float64(dt.dirsSize-dt.subdirs[0].dirsSize) / float64(dt.size); math.Abs(sum+own-100) > 1e-9 {
+		t.Errorf("Expecting the percentages to add up to 100 and not %v", sum+own)
+	}
+}
+
+func Test_PrintNormalizeUnicode(t *testing.T) {
+	// "é" decomposed into "e" and the combining acute accent, as HFS+
+	// stores it
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "cafe\u0301", "re\u0301sume\u0301.txt"), 3456},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	want := []string{
+		testFilesRoot + "/caf\u00e9/r\u00e9sum\u00e9.txt",
+		testFilesRoot + "/caf\u00e9",
+		testFilesRoot,
+	}
+	if got := dt.Print(PrintOptions{Format: "%[2]s", CountFiles: true, NormalizeUnicode: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting the paths in NFC %q and not %q", want, got)
+	}
+	// The paths are written as they are otherwise
+	if got := dt.Print(PrintOptions{Format: "%[2]s"}); got[0] != testFilesRoot+"/cafe\u0301" {
+		t.Errorf("Expecting the path in NFD %q and not %q", testFilesRoot+"/cafe\u0301", got[0])
+	}
+}
+
+func Test_PrintPrefix(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "subdir", "under_4k.txt"), 3456},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	want := []string{
+		"/backup/testdata/subdir/under_4k.txt",
+		"/backup/testdata/subdir",
+		"/backup/testdata",
+	}
+	if got := dt.Print(PrintOptions{Format: "%[2]s", CountFiles: true, Prefix: "/backup"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting the prefixed paths %q and not %q", want, got)
+	}
+	// The name of a combined tree is not a path
+	total := Combine("total", dt)
+	if got := total.Print(PrintOptions{Format: "%[2]s", Summarise: true, Prefix: "/backup"}); got[len(got)-1] != "total" {
+		t.Errorf("Expecting the total not to be prefixed and not %q", got)
+	}
+}
+
+func Test_PrintStale(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "new.txt"), 3456},
+		{filepath.Join(testFilesRoot, "archive", "old.txt"), 3456},
+		{filepath.Join(testFilesRoot, "archive", "nested", "old.txt"), 3456},
+		{filepath.Join(testFilesRoot, "mixed", "old.txt"), 3456},
+		{filepath.Join(testFilesRoot, "mixed", "new.txt"), 3456},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"archive/nested/old.txt", "archive/nested", "archive/old.txt", "archive", "mixed/old.txt", "mixed"} {
+		if err := os.Chtimes(filepath.Join(testFilesRoot, name), old, old); err != nil {
+			t.Fatalf("Failed to create test data: %v", err)
+		}
+	}
+
+	dt := New(testFilesRoot, 512)
+	got := dt.Print(PrintOptions{Format: "%[2]s", StaleBefore: time.Now().Add(-24 * time.Hour)})
+	want := []string{
+		testFilesRoot + "/archive/nested [stale]",
+		testFilesRoot + "/archive [stale]",
+		testFilesRoot + "/mixed",
+		testFilesRoot,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting the stale directories marked %q and not %q", want, got)
+	}
+	// The files themselves are not marked
+	got = dt.Print(PrintOptions{Format: "%[2]s", CountFiles: true, StaleBefore: time.Now().Add(-24 * time.Hour)})
+	if got[1] != testFilesRoot+"/archive/old.txt" {
+		t.Errorf("Expecting the file not to be marked and not %q", got[1])
+	}
+}
+
+func Test_PrintBlocks(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	for _, line := range dt.Print(PrintOptions{Format: "%d\t%s", CountFiles: true, Blocks: true}) {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			t.Fatalf("Expecting the size, the blocks and the path in %q", line)
+		}
+		info, err := os.Lstat(fields[2])
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if want := strconv.FormatInt(int64(info.Sys().(*syscall.Stat_t).Blocks), 10); fields[1] != want {
+			t.Errorf("Expecting %s blocks for %s and not %s", want, fields[2], fields[1])
+		}
+	}
+}
+
+func Test_PrintChildren(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "subdir", "a.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "b.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "nested", "c.txt"), 3456},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	// Only the entries directly in a directory are counted
+	dt := New(testFilesRoot, 512)
+	got := dt.Print(PrintOptions{Format: "%d\t%s", Children: true})
+	want := []string{
+		"16\t1 items\t" + testFilesRoot + "/subdir/nested",
+		"40\t3 items\t" + testFilesRoot + "/subdir",
+		"72\t3 items\t" + testFilesRoot,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting the children counted %q and not %q", want, got)
+	}
+	// The trees combined are the children of the total
+	total := Combine("total", dt, dt)
+	if got := total.Print(PrintOptions{Format: "%d\t%s", Summarise: true, Children: true}); got[0] != "144\t2 items\ttotal" {
+		t.Errorf("Expecting the total with 2 children and not %q", got)
+	}
+}
+
+// sizeInfo is a file that pretends to be `size` bytes long.
+type sizeInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i sizeInfo) Size() int64 {
+	return i.size
+}
+
+// sizeEntry is a directory entry of a file that pretends to be `size` bytes
+// long.
+type sizeEntry struct {
+	os.DirEntry
+	size int64
+}
+
+func (e sizeEntry) Info() (os.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	return sizeInfo{info, e.size}, err
+}
+
+func Test_SizeOverflow(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "a.txt"), 10},
+		{filepath.Join(testFilesRoot, "b.txt"), 10},
+		{filepath.Join(testFilesRoot, "c.txt"), 10},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	// Each file is a bit less than half of the biggest int64
+	readDir = func(name string) ([]os.DirEntry, error) {
+		entries, err := os.ReadDir(name)
+		for i, e := range entries {
+			entries[i] = sizeEntry{e, math.MaxInt64/2 - 10}
+		}
+		return entries, err
+	}
+	defer func() { readDir = os.ReadDir }()
+	var buf bytes.Buffer
+	errLog.SetOutput(&buf)
+	defer errLog.SetOutput(os.Stderr)
+
+	s := NewScanner(1)
+	s.ApparentSize = true
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if dt.size != math.MaxInt64 {
+		t.Errorf("Expecting the size to be capped at %d and not %d", int64(math.MaxInt64), dt.size)
+	}
+	if got := strings.Count(buf.String(), "too big"); got != 1 {
+		t.Errorf("Expecting one warning about the size and not %q", buf.String())
+	}
+}
+
+func Test_BytesOverflow(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "a.txt"), 10},
+		{filepath.Join(testFilesRoot, "b.txt"), 10},
+		{filepath.Join(testFilesRoot, "c.txt"), 10},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	// The sizes in units fit into an int64, in bytes they don't
+	readDir = func(name string) ([]os.DirEntry, error) {
+		entries, err := os.ReadDir(name)
+		for i, e := range entries {
+			entries[i] = sizeEntry{e, math.MaxInt64/2 - 10}
+		}
+		return entries, err
+	}
+	defer func() { readDir = os.ReadDir }()
+	var buf bytes.Buffer
+	errLog.SetOutput(&buf)
+	defer errLog.SetOutput(os.Stderr)
+
+	s := NewScanner(512)
+	s.ApparentSize = true
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if dt.size == math.MaxInt64 {
+		t.Fatalf("Expecting the size in units not to be capped")
+	}
+	if dt.apparentBytes != math.MaxInt64 {
+		t.Errorf("Expecting the apparent size to be capped at %d and not %d", int64(math.MaxInt64), dt.apparentBytes)
+	}
+	max := strconv.FormatInt(math.MaxInt64, 10)
+	if got, want := dt.PrintTotal(PrintOptions{}), max+" "+testFilesRoot; got != want {
+		t.Errorf("Expecting the total %q and not %q", want, got)
+	}
+	if got := dt.Print(PrintOptions{Format: "%d\t%s", Summarise: true, Human: true, Bytes: true}); got[0] != max+"\t8.0E\t"+testFilesRoot {
+		t.Errorf("Expecting the sizes in bytes to be capped and not %q", got)
+	}
+	if got := strings.Join(dt.PrintJSON(PrintOptions{}), ""); !strings.Contains(got, `"bytes":`+max) {
+		t.Errorf("Expecting the bytes in JSON to be capped and not %q", got)
+	}
+}
+
+func Test_GenerateTree(t *testing.T) {
+	defer resetTestData()
+	if err := generateTree(testFilesRoot, 3, 2); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	dt := New(testFilesRoot, 512)
+	// 1 + 3 + 9 directories with 3 files each
+	if dt.fileCount != 39 {
+		t.Errorf("Expecting 39 files and not %d", dt.fileCount)
+	}
+	if out := dt.Print(PrintOptions{Format: "%d\t%s"}); len(out) != 13 {
+		t.Errorf("Expecting 13 directories and not %d", len(out))
+	}
+}
+
+// The trees the benchmarks run on, see generateTree.
+var benchmarkTrees = []struct {
+	width, depth int
+}{
+	{10, 2},
+	{4, 5},
+}
+
+func Benchmark_BuildTree(b *testing.B) {
+	for _, bt := range benchmarkTrees {
+		if err := generateTree(testFilesRoot, bt.width, bt.depth); err != nil {
+			b.Fatalf("Failed to create test data: %v", err)
+		}
+		b.Run(fmt.Sprintf("width=%d,depth=%d", bt.width, bt.depth), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				NewScanner(512).Scan(testFilesRoot)
+			}
+		})
+		resetTestData()
+	}
+}
+
+func Benchmark_PrintDirTree(b *testing.B) {
+	for _, bt := range benchmarkTrees {
+		if err := generateTree(testFilesRoot, bt.width, bt.depth); err != nil {
+			b.Fatalf("Failed to create test data: %v", err)
+		}
+		dt := New(testFilesRoot, 512)
+		resetTestData()
+		b.Run(fmt.Sprintf("width=%d,depth=%d", bt.width, bt.depth), func(b *testing.B) {
+			opts := PrintOptions{Format: "%d\t%s", CountFiles: true}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				dt.Print(opts)
+			}
+		})
+	}
+}
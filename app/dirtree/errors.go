@@ -0,0 +1,112 @@
+package dirtree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// The errors that a ScanError can be matched against with errors.Is
+// regardless of the system errors it wraps. They wrap the matching errors of
+// the os package in turn.
+var (
+	ErrNotExist   = fmt.Errorf("file does not exist: %w", os.ErrNotExist)
+	ErrPermission = fmt.Errorf("permission denied: %w", os.ErrPermission)
+)
+
+// ScanError records an error encountered while scanning a file or a
+// directory together with the path that caused it.
+type ScanError struct {
+	// What the scanner tried to do, for example "read directory".
+	Op string
+	// The file or directory that failed.
+	Path string
+	// The underlying error, usually an *os.PathError.
+	Err error
+}
+
+// Error implements the error interface. The message follows the style of
+// GNU du, for example "cannot read directory 'dir': permission denied".
+func (e *ScanError) Error() string {
+	err := e.Err
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	return fmt.Sprintf("cannot %s '%s': %v", e.Op, e.Path, err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether `e` matches `target`, which is one of ErrNotExist and
+// ErrPermission.
+func (e *ScanError) Is(target error) bool {
+	switch target {
+	case ErrNotExist:
+		return errors.Is(e.Err, os.ErrNotExist)
+	case ErrPermission:
+		return errors.Is(e.Err, os.ErrPermission)
+	}
+	return false
+}
+
+// report prints out `err` to stderr. The full ScanError is printed if
+// VerboseErrors is set, otherwise just the underlying error.
+func (s *Scanner) report(err *ScanError) {
+	s.printError(err, s.VerboseErrors)
+}
+
+// reportRoot reports `err` for the root of a scan. The full ScanError is
+// always printed, like GNU du does, e.g. "cannot read directory 'dir':
+// permission denied", so that it is clear that the argument itself could
+// not be read rather than something in it. Only its own size is counted
+// then.
+func (s *Scanner) reportRoot(err *ScanError) {
+	s.printError(err, true)
+}
+
+// printError collects `err` and prints it out to stderr, in full if
+// `verbose` is set.
+func (s *Scanner) printError(err *ScanError, verbose bool) {
+	s.errs = append(s.errs, err)
+	if s.FailFast && s.cancel != nil {
+		s.cancel()
+	}
+	if s.quiet {
+		return
+	}
+	if verbose {
+		errLog.Println(err)
+	} else {
+		errLog.Println(err.Err)
+	}
+}
+
+// reportEntry reports `err` for a file or a directory found while reading
+// its parent directory. If the file doesn't exist any more it was deleted
+// during the scan, which is not a failure: it is skipped silently and only
+// mentioned if VerboseErrors is set.
+func (s *Scanner) reportEntry(err *ScanError) {
+	if !errors.Is(err, os.ErrNotExist) {
+		s.report(err)
+		return
+	}
+	if s.VerboseErrors && !s.quiet {
+		errLog.Printf("'%s' vanished during the scan, skipping it", err.Path)
+	}
+}
+
+// Failed reports whether any errors were reported since the scanner was
+// created, which means that some of the sizes may be incomplete.
+func (s *Scanner) Failed() bool {
+	return len(s.errs) > 0
+}
+
+// Errors returns all the errors reported since the scanner was created, in
+// the order they were encountered.
+func (s *Scanner) Errors() []*ScanError {
+	return s.errs
+}
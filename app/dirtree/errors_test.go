@@ -0,0 +1,204 @@
+package dirtree
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func Test_ScanErrorMessage(t *testing.T) {
+	err := &ScanError{
+		Op:   "read directory",
+		Path: "dir/sub",
+		Err:  &os.PathError{Op: "open", Path: "dir/sub", Err: syscall.EACCES},
+	}
+	if want := "cannot read directory 'dir/sub': permission denied"; err.Error() != want {
+		t.Errorf("Expecting error message %q and not %q", want, err.Error())
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Expecting %v to wrap %v", err, os.ErrPermission)
+	}
+	if !errors.Is(err, ErrPermission) || errors.Is(err, ErrNotExist) {
+		t.Errorf("Expecting %v to match %v only", err, ErrPermission)
+	}
+	if !errors.Is(ErrNotExist, os.ErrNotExist) {
+		t.Errorf("Expecting %v to wrap %v", ErrNotExist, os.ErrNotExist)
+	}
+}
+
+func Test_VerboseErrors(t *testing.T) {
+	var buf bytes.Buffer
+	errLog.SetOutput(&buf)
+	defer errLog.SetOutput(os.Stderr)
+
+	path := filepath.Join(testFilesRoot, "ak5i8fg74")
+	s := NewScanner(512)
+	s.VerboseErrors = true
+	_, err := s.Scan(path)
+	var serr *ScanError
+	if !errors.As(err, &serr) || serr.Path != path {
+		t.Errorf("Expecting a ScanError for %s and not %v", path, err)
+	}
+	want := "cannot access '" + path + "': no such file or directory"
+	if got := strings.TrimSpace(buf.String()); got != want {
+		t.Errorf("Expecting error message %q and not %q", want, got)
+	}
+}
+
+func Test_VerboseErrorsReadDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Permissions are not enforced for root")
+	}
+	if err := os.MkdirAll(testFilesRoot, 0755); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(testFilesRoot, "subdir"), 0000); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	defer os.Chmod(filepath.Join(testFilesRoot, "subdir"), 0755)
+
+	var buf bytes.Buffer
+	errLog.SetOutput(&buf)
+	defer errLog.SetOutput(os.Stderr)
+
+	s := NewScanner(512)
+	s.VerboseErrors = true
+	s.Scan(testFilesRoot)
+	want := "cannot read directory '" + filepath.Join(testFilesRoot, "subdir") + "': permission denied"
+	if got := strings.TrimSpace(buf.String()); got != want {
+		t.Errorf("Expecting error message %q and not %q", want, got)
+	}
+	// The error is collected as well
+	errs := s.Errors()
+	if len(errs) != 1 || !errors.Is(errs[0], ErrPermission) {
+		t.Errorf("Expecting a single %v error and not %v", ErrPermission, errs)
+	}
+}
+
+func Test_VanishedFiles(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	var buf bytes.Buffer
+	errLog.SetOutput(&buf)
+	defer errLog.SetOutput(os.Stderr)
+
+	s := NewScanner(512)
+	// Delete everything as soon as the root directory has been read
+	steps := 0
+	s.Progress = func(float64) {
+		if steps++; steps == 2 {
+			os.Remove(files[0].path)
+			os.RemoveAll(filepath.Join(testFilesRoot, "subdir"))
+		}
+	}
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.Failed() {
+		t.Errorf("Expecting vanished files not to fail the scan")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expecting vanished files not to be reported and not %q", buf.String())
+	}
+	if dt.size != dt.calcSize(4096) {
+		t.Errorf("Expecting only the root directory to be counted and not %v", dt.size)
+	}
+
+	// Other errors fail the scan
+	s.Scan(filepath.Join(testFilesRoot, "ak5i8fg74"))
+	if !s.Failed() {
+		t.Errorf("Expecting a missing argument to fail the scan")
+	}
+	if errs := s.Errors(); len(errs) != 1 || !errors.Is(errs[0], ErrNotExist) {
+		t.Errorf("Expecting a single %v error and not %v", ErrNotExist, errs)
+	}
+}
+
+func Test_FailFast(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Permissions are not enforced for root")
+	}
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "b", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	// Read before "b"
+	if err := os.Mkdir(filepath.Join(testFilesRoot, "a"), 0000); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer os.Chmod(filepath.Join(testFilesRoot, "a"), 0755)
+
+	errLog.SetOutput(ioutil.Discard)
+	defer errLog.SetOutput(os.Stderr)
+
+	s := NewScanner(512)
+	s.FailFast = true
+	dt, err := s.Scan(testFilesRoot)
+	if !errors.Is(err, ErrPermission) {
+		t.Errorf("Expecting a permission error and not %v", err)
+	}
+	// Nothing is scanned after the error
+	if len(dt.subdirs) != 1 {
+		t.Errorf("Expecting the scan to stop after the first error and not %d sub-directories", len(dt.subdirs))
+	}
+
+	// Without it the scan goes on
+	s = NewScanner(512)
+	dt, err = s.Scan(testFilesRoot)
+	if err != nil || len(dt.subdirs) != 2 {
+		t.Errorf("Expecting the scan to go on after an error, got %v and %d sub-directories", err, len(dt.subdirs))
+	}
+}
+
+func Test_UnreadableRoot(t *testing.T) {
+	if err := createTestData([]testFile{{filepath.Join(testFilesRoot, "under_4k.txt"), 3456}}); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	readDir = func(name string) ([]os.DirEntry, error) {
+		if name == testFilesRoot {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EACCES}
+		}
+		return os.ReadDir(name)
+	}
+	defer func() { readDir = os.ReadDir }()
+	var buf bytes.Buffer
+	errLog.SetOutput(&buf)
+	defer errLog.SetOutput(os.Stderr)
+
+	// The error is printed in full even without VerboseErrors
+	s := NewScanner(512)
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "cannot read directory '" + testFilesRoot + "': permission denied"
+	if got := strings.TrimSpace(buf.String()); got != want {
+		t.Errorf("Expecting error message %q and not %q", want, got)
+	}
+	if errs := s.Errors(); len(errs) != 1 || !errors.Is(errs[0], ErrPermission) {
+		t.Errorf("Expecting a single %v error and not %v", ErrPermission, errs)
+	}
+	// Only the size of the directory itself is counted
+	info, _ := os.Stat(testFilesRoot)
+	if want := dt.countableSize(info); dt.size != want || dt.fileCount != 0 {
+		t.Errorf("Expecting the size %d of the directory itself and not %d of %d files", want, dt.size, dt.fileCount)
+	}
+}
@@ -0,0 +1,76 @@
+package dirtree
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// An extent of a file, a range of bytes stored contiguously on the device.
+type extent struct {
+	// Where the extent starts on the device and how long it is, in bytes.
+	physical, length uint64
+	// The extent may be shared with other files, e.g. by a reflink copy.
+	shared bool
+}
+
+// The identity of an extent, its device and its physical offset on it.
+type extentID struct {
+	dev      uint64
+	physical uint64
+}
+
+// The error returned by fileExtents if the extents of the files cannot be
+// looked up, because the program is built without FIEMAP support.
+var errNoExtents = errors.New("the extents of the files are not available in this build")
+
+// sharedBytes returns the number of bytes of the regular file at `path`,
+// described by `info`, that are in the shared extents already counted for
+// other files, see Scanner.DedupExtents. The shared extents of the file are
+// remembered, so that they are not counted again for the next files.
+//
+// The extents are matched by their physical offsets, two files sharing only
+// a part of an extent starting at different offsets are not detected. If the
+// extents cannot be looked up, e.g. because the filesystem doesn't support
+// FIEMAP, the file is counted in full.
+func (s *Scanner) sharedBytes(path string, info os.FileInfo) int64 {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || !info.Mode().IsRegular() || st.Blocks == 0 {
+		return 0
+	}
+	extents, err := fileExtents(path)
+	if err != nil {
+		return 0
+	}
+	var shared int64
+	for _, e := range extents {
+		if !e.shared {
+			continue
+		}
+		id := extentID{dev: uint64(st.Dev), physical: e.physical}
+		seen := s.extents[id]
+		if seen < e.length {
+			s.extents[id] = e.length
+		} else {
+			seen = e.length
+		}
+		shared = shared + int64(seen)
+	}
+
+	return shared
+}
+
+// dedupSize returns the size in units of the file described by `info` with
+// `shared` bytes already counted for other files left out. The rest is what
+// is allocated to the file only.
+func (dt *DirTree) dedupSize(info os.FileInfo, shared int64) int64 {
+	if shared == 0 {
+		return dt.countableSize(info)
+	}
+	own := dt.allocated(info) - shared
+	if own < 0 {
+		own = 0
+	}
+
+	return dt.toUnits(own)
+}
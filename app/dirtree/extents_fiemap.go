@@ -0,0 +1,78 @@
+//go:build linux && fiemap
+// +build linux,fiemap
+
+package dirtree
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// DedupExtentsSupported tells whether Scanner.DedupExtents is supported by
+// this build. It is only with the fiemap build tag.
+const DedupExtentsSupported = true
+
+// The FIEMAP ioctl, see linux/fiemap.h.
+const (
+	fsIocFiemap        = 0xC020660B
+	fiemapFlagSync     = 0x0001
+	fiemapExtentLast   = 0x0001
+	fiemapExtentShared = 0x2000
+	// The number of extents asked for in one call.
+	fiemapBatch = 128
+)
+
+// struct fiemap_extent
+type fiemapExtent struct {
+	logical  uint64
+	physical uint64
+	length   uint64
+	_        [2]uint64
+	flags    uint32
+	_        [3]uint32
+}
+
+// struct fiemap followed by the space for the extents.
+type fiemapRequest struct {
+	start         uint64
+	length        uint64
+	flags         uint32
+	mappedExtents uint32
+	extentCount   uint32
+	_             uint32
+	extents       [fiemapBatch]fiemapExtent
+}
+
+// The extents of a file, a variable so that the tests can fake them.
+var fileExtents = fiemap
+
+// fiemap returns the extents of the file at `path` using the FIEMAP ioctl.
+func fiemap(path string) ([]extent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var extents []extent
+	var req fiemapRequest
+	var start uint64
+	for {
+		req = fiemapRequest{start: start, length: ^uint64(0) - start, flags: fiemapFlagSync, extentCount: fiemapBatch}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(&req)))
+		if errno != 0 {
+			return nil, &os.PathError{Op: "fiemap", Path: path, Err: errno}
+		}
+		if req.mappedExtents == 0 {
+			return extents, nil
+		}
+		for _, e := range req.extents[:req.mappedExtents] {
+			extents = append(extents, extent{physical: e.physical, length: e.length, shared: e.flags&fiemapExtentShared != 0})
+			if e.flags&fiemapExtentLast != 0 {
+				return extents, nil
+			}
+		}
+		last := req.extents[req.mappedExtents-1]
+		start = last.logical + last.length
+	}
+}
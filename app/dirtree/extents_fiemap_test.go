@@ -0,0 +1,82 @@
+//go:build linux && fiemap
+// +build linux,fiemap
+
+package dirtree
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// The FICLONE ioctl, see linux/fs.h.
+const ficlone = 0x40049409
+
+// reflink makes `dst` a copy of `src` that shares all its extents, like
+// cp --reflink=always does.
+func reflink(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd()); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+func Test_Fiemap(t *testing.T) {
+	file := filepath.Join(testFilesRoot, "a.txt")
+	if err := createDummyFile(file, 1<<20); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	extents, err := fiemap(file)
+	if err != nil {
+		t.Skipf("FIEMAP is not supported by the filesystem of the test data: %v", err)
+	}
+	var length uint64
+	for _, e := range extents {
+		length = length + e.length
+	}
+	if length < 1<<20 {
+		t.Errorf("Expecting the extents of %s to be at least %d bytes long and not %d", file, 1<<20, length)
+	}
+	if _, err := fiemap(filepath.Join(testFilesRoot, "missing")); err == nil {
+		t.Errorf("Expecting an error for a missing file")
+	}
+}
+
+// The test needs a filesystem with reflinks for the test data, such as
+// Btrfs or XFS, it is skipped on the others.
+func Test_ScannerDedupReflinks(t *testing.T) {
+	a := filepath.Join(testFilesRoot, "a.txt")
+	b := filepath.Join(testFilesRoot, "b.txt")
+	if err := createDummyFile(a, 1<<20); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	if err := reflink(a, b); err != nil {
+		t.Skipf("Reflinks are not supported by the filesystem of the test data: %v", err)
+	}
+
+	s := NewScanner(1)
+	s.ExactAllocated = true
+	s.DedupExtents = true
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dt.files) != 2 || dt.files[0].size < 1<<20 || dt.files[1].size != 0 {
+		t.Errorf("Expecting a reflink copy to take no space of its own and not %+v", dt.files)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux || !fiemap
+// +build !linux !fiemap
+
+package dirtree
+
+// DedupExtentsSupported tells whether Scanner.DedupExtents is supported by
+// this build. It is only with the fiemap build tag.
+const DedupExtentsSupported = false
+
+// The extents of a file, a variable so that the tests can fake them.
+var fileExtents = func(path string) ([]extent, error) {
+	return nil, errNoExtents
+}
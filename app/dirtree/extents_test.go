@@ -0,0 +1,61 @@
+package dirtree
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ScannerDedupExtents(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "a.txt"), 8192},
+		{filepath.Join(testFilesRoot, "b.txt"), 8192},
+		{filepath.Join(testFilesRoot, "c.txt"), 8192},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	// a.txt and b.txt share all their extents, like a reflink copy, c.txt
+	// shares the second half with them
+	fake := map[string][]extent{
+		"a.txt": {{physical: 1 << 20, length: 4096, shared: true}, {physical: 2 << 20, length: 4096, shared: true}},
+		"b.txt": {{physical: 1 << 20, length: 4096, shared: true}, {physical: 2 << 20, length: 4096, shared: true}},
+		"c.txt": {{physical: 3 << 20, length: 4096}, {physical: 2 << 20, length: 4096, shared: true}},
+	}
+	defer func(old func(string) ([]extent, error)) { fileExtents = old }(fileExtents)
+	fileExtents = func(path string) ([]extent, error) {
+		return fake[filepath.Base(path)], nil
+	}
+
+	// The files may take more space than their content, e.g. on a
+	// filesystem with bigger blocks, the rest is theirs only
+	own := func(name string, shared int64) int64 {
+		info, err := os.Lstat(filepath.Join(testFilesRoot, name))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return (&DirTree{blockSize: defaultBlockSize}).allocated(info) - shared
+	}
+	for _, tc := range []struct {
+		dedup bool
+		want  map[string]int64
+	}{
+		{false, map[string]int64{"a.txt": own("a.txt", 0), "b.txt": own("b.txt", 0), "c.txt": own("c.txt", 0)}},
+		{true, map[string]int64{"a.txt": own("a.txt", 0), "b.txt": own("b.txt", 8192), "c.txt": own("c.txt", 4096)}},
+	} {
+		s := NewScanner(1)
+		s.ExactAllocated = true
+		s.DedupExtents = tc.dedup
+		dt, err := s.Scan(testFilesRoot)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, f := range dt.files {
+			name := filepath.Base(f.path)
+			if f.size != tc.want[name] {
+				t.Errorf("Expecting %s to be %d bytes with DedupExtents %v and not %d", name, tc.want[name], tc.dedup, f.size)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package dirtree
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A formatter that writes the entries indented by their depth.
+type indentFormatter struct{}
+
+func (indentFormatter) FormatEntry(size int64, path string, depth int) string {
+	return fmt.Sprintf("%s%s (%d)", strings.Repeat("  ", depth), filepath.Base(path), size)
+}
+
+func (indentFormatter) FormatTotal(size int64, path string) string {
+	return fmt.Sprintf("total %d", size)
+}
+
+func Test_PrintFormatter(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	RegisterFormatter("indent", indentFormatter{})
+	f, ok := LookupFormatter("indent")
+	if !ok {
+		t.Fatalf("Expecting the registered formatter to be found")
+	}
+	dt := New(testFilesRoot, 512)
+	got := dt.Print(PrintOptions{CountFiles: true, Formatter: f})
+	want := []string{"  under_4k.txt (8)", "    over_4k.txt (16)", "  subdir (24)", "total 40"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	if _, ok := LookupFormatter("tree"); ok {
+		t.Errorf("Expecting no formatter registered as tree")
+	}
+}
+
+func Test_BuiltinFormatters(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+		total string
+	}{
+		{"plain", "8\tdir/a.txt", "40\tdir"},
+		{"json", `{"path":"dir/a.txt","size":8,"depth":1}`, `{"path":"dir","size":40,"depth":0}`},
+		{"csv", "8,dir/a.txt", "40,dir"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, ok := LookupFormatter(tt.name)
+			if !ok {
+				t.Fatalf("Expecting a built-in formatter %q", tt.name)
+			}
+			if got := f.FormatEntry(8, "dir/a.txt", 1); got != tt.entry {
+				t.Errorf("Expecting entry %q and not %q", tt.entry, got)
+			}
+			if got := f.FormatTotal(40, "dir"); got != tt.total {
+				t.Errorf("Expecting total %q and not %q", tt.total, got)
+			}
+		})
+	}
+	if got := (CSVFormatter{}).FormatEntry(8, `a, "b".txt`, 1); got != `8,"a, ""b"".txt"` {
+		t.Errorf("Expecting the path to be quoted and not %q", got)
+	}
+}
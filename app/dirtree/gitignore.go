@@ -0,0 +1,144 @@
+package dirtree
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A single pattern from a .gitignore file.
+// See https://git-scm.com/docs/gitignore#_pattern_format
+type ignoreRule struct {
+	// The directory of the .gitignore file the pattern comes from.
+	base string
+	// The pattern split by "/", without the leading "!" and slashes.
+	segments []string
+	// The pattern starts with "!", matching files are not ignored.
+	negate bool
+	// The pattern ends with "/", it only matches directories.
+	dirOnly bool
+	// The pattern has a slash at the beginning or in the middle, it is
+	// matched against the path relative to `base` rather than the name.
+	anchored bool
+}
+
+// readGitignore reads the .gitignore file in `dir` if there is one.
+func readGitignore(dir string) []ignoreRule {
+	f, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if r, ok := parseIgnoreRule(dir, sc.Text()); ok {
+			rules = append(rules, r)
+		}
+	}
+
+	return rules
+}
+
+// parseIgnoreRule parses a single line of a .gitignore file in `dir`.
+// Returns false if the line has no pattern, e.g. it is a comment.
+func parseIgnoreRule(dir, line string) (ignoreRule, bool) {
+	r := ignoreRule{base: dir}
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || line[0] == '#' {
+		return r, false
+	}
+	if line[0] == '!' {
+		r.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' {
+		// "\#" and "\!" start with a literal "#" or "!"
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimLeft(line, "/")
+	}
+	if line == "" {
+		return r, false
+	}
+	r.segments = strings.Split(line, "/")
+
+	return r, true
+}
+
+// matches reports whether the rule matches the file or directory at `path`.
+func (r ignoreRule) matches(path string, dir bool) bool {
+	if r.dirOnly && !dir {
+		return false
+	}
+	if !r.anchored {
+		ok, _ := filepath.Match(r.segments[0], filepath.Base(path))
+		return ok
+	}
+	rel, err := filepath.Rel(r.base, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+
+	return matchSegments(r.segments, strings.Split(filepath.ToSlash(rel), "/"))
+}
+
+// matchSegments matches a path split by "/" against a pattern split by "/".
+// A "**" segment in the pattern matches zero or more segments of the path.
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+
+	return len(path) == 0
+}
+
+// gitignored reports whether the file or directory at `path` is ignored by
+// the .gitignore files read so far. The last matching rule wins, so that a
+// negated rule can bring back a file ignored by an earlier one and the
+// rules from the sub-directories take precedence over the ones from their
+// parents.
+func (s *Scanner) gitignored(path string, dir bool) bool {
+	ignored := false
+	for _, r := range s.ignore {
+		if r.matches(path, dir) {
+			ignored = !r.negate
+		}
+	}
+
+	return ignored
+}
+
+// readIgnoreRules adds the rules from the .gitignore file in `dir` if
+// GitIgnore is set. The caller should call the returned function when done
+// with the directory to drop them.
+func (s *Scanner) readIgnoreRules(dir string) func() {
+	if !s.GitIgnore {
+		return func() {}
+	}
+	n := len(s.ignore)
+	s.ignore = append(s.ignore, readGitignore(dir)...)
+
+	return func() { s.ignore = s.ignore[:n] }
+}
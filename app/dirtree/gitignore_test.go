@@ -0,0 +1,98 @@
+package dirtree
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func Test_IgnoreRuleMatches(t *testing.T) {
+	var tests = []struct {
+		line string
+		path string
+		dir  bool
+		want bool
+	}{
+		{"*.log", "base/a.log", false, true},
+		{"*.log", "base/sub/a.log", false, true},
+		{"*.log", "base/a.txt", false, false},
+		{"build/", "base/build", true, true},
+		{"build/", "base/build", false, false},
+		{"/build", "base/build", true, true},
+		{"/build", "base/sub/build", true, false},
+		{"doc/*.txt", "base/doc/a.txt", false, true},
+		{"doc/*.txt", "base/doc/sub/a.txt", false, false},
+		{"**/cache", "base/a/b/cache", true, true},
+		{"**/cache", "base/cache", true, true},
+		{"a/**/z", "base/a/z", false, true},
+		{"a/**/z", "base/a/b/c/z", false, true},
+		{"a/**", "base/a/b", false, true},
+		{"\\#hash", "base/#hash", false, true},
+		{"/..cache", "base/..cache", true, true},
+		{"/cache", "other/cache", true, false},
+	}
+	for _, tt := range tests {
+		r, ok := parseIgnoreRule("base", tt.line)
+		if !ok {
+			t.Errorf("Expecting %q to be a rule", tt.line)
+			continue
+		}
+		if got := r.matches(tt.path, tt.dir); got != tt.want {
+			t.Errorf("Expecting %q matching %s (dir: %v) to be %v and not %v", tt.line, tt.path, tt.dir, tt.want, got)
+		}
+	}
+	for _, line := range []string{"", "   ", "# comment", "/"} {
+		if _, ok := parseIgnoreRule("base", line); ok {
+			t.Errorf("Expecting %q not to be a rule", line)
+		}
+	}
+}
+
+func Test_ScannerGitIgnore(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "debug.log"), 3456},
+		{filepath.Join(testFilesRoot, "keep.log"), 3456},
+		{filepath.Join(testFilesRoot, "build", "over_4m.txt"), 5678 * 1024},
+		{filepath.Join(testFilesRoot, "src", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "src", "tmp", "under_4k.txt"), 3456},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	ignores := map[string]string{
+		testFilesRoot:                       "# build output\nbuild/\n*.log\n!keep.log\n",
+		filepath.Join(testFilesRoot, "src"): "tmp/\n",
+	}
+	for dir, content := range ignores {
+		if err := ioutil.WriteFile(filepath.Join(dir, ".gitignore"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test data: %v", err)
+		}
+	}
+
+	s := NewScanner(512)
+	s.GitIgnore = true
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	out := dt.Print(PrintOptions{Format: "%d\t%s", CountFiles: true})
+	want := []string{
+		"8\t" + testFilesRoot + "/.gitignore",
+		"8\t" + testFilesRoot + "/keep.log",
+		"8\t" + testFilesRoot + "/under_4k.txt",
+		"8\t" + testFilesRoot + "/src/.gitignore",
+		"16\t" + testFilesRoot + "/src/over_4k.txt",
+		"32\t" + testFilesRoot + "/src",
+		"64\t" + testFilesRoot,
+	}
+	if len(out) != len(want) {
+		t.Fatalf("Expecting %d lines and not %d: %q", len(want), len(out), out)
+	}
+	for i, v := range out {
+		if v != want[i] {
+			t.Errorf("Output string #%v %q is not equal to the expected one %q", i, v, want[i])
+		}
+	}
+}
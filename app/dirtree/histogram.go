@@ -0,0 +1,58 @@
+package dirtree
+
+import "fmt"
+
+// histogramBucket is a range of file lengths and the files that fall into
+// it.
+type histogramBucket struct {
+	label string
+	max   int64 // files shorter than max bytes fall into the bucket
+	count int64
+	size  int64 // disk usage in units
+}
+
+// histogramBuckets returns the empty buckets the files are sorted into by
+// PrintHistogram, from the smallest files to the biggest.
+func histogramBuckets() []histogramBucket {
+	return []histogramBucket{
+		{label: "<4K", max: 4 << 10},
+		{label: "4K-64K", max: 64 << 10},
+		{label: "64K-1M", max: 1 << 20},
+		{label: ">=1M", max: -1},
+	}
+}
+
+// histogram adds the files of `dt` and all its sub-directories to the
+// `buckets`.
+func (dt *DirTree) histogram(buckets []histogramBucket) {
+	for _, f := range dt.files {
+		for i := range buckets {
+			if buckets[i].max < 0 || f.length < buckets[i].max {
+				buckets[i].count++
+				buckets[i].size = buckets[i].size + f.size
+				break
+			}
+		}
+	}
+	for _, d := range dt.subdirs {
+		d.histogram(buckets)
+	}
+}
+
+// PrintHistogram returns a line for each range of file lengths with the
+// total size and the number of the files in `dt` that fall into it,
+// separated by tabs, for example "832\t1547\t4K-64K\t./dir". This shows
+// whether the space is taken by many small files or by a few big ones. All
+// the files of the tree are counted, unless the scanner skipped them, see
+// Scanner.SkipFiles. The `opts.Format` is ignored.
+func (dt *DirTree) PrintHistogram(opts PrintOptions) []string {
+	buckets := histogramBuckets()
+	dt.histogram(buckets)
+	path := opts.path(dt.dirEntry())
+	var out []string
+	for _, b := range buckets {
+		out = append(out, fmt.Sprintf("%d\t%d\t%s\t%s", dt.sizeField(b.size, &opts), b.count, b.label, path))
+	}
+
+	return out
+}
@@ -0,0 +1,34 @@
+package dirtree
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_PrintHistogram(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "empty.txt"), 0},
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "subdir", "nested", "over_64k.txt"), 70000},
+		{filepath.Join(testFilesRoot, "subdir", "nested", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 1024)
+	got := dt.PrintHistogram(PrintOptions{})
+	want := []string{
+		"8\t3\t<4K\t" + testFilesRoot,
+		"8\t1\t4K-64K\t" + testFilesRoot,
+		"72\t1\t64K-1M\t" + testFilesRoot,
+		"5680\t1\t>=1M\t" + testFilesRoot,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting histogram %q and not %q", want, got)
+	}
+}
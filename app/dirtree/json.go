@@ -0,0 +1,240 @@
+package dirtree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// A single file or directory in the JSON output.
+type jsonEntry struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Depth int    `json:"depth"`
+	Dir   bool   `json:"dir"`
+}
+
+// PrintJSONLines walks over `dt` recursively and returns a slice of JSON
+// objects, one for each file or directory, in the same order as Print. Each
+// object has the path and the size of the entry and its depth relative to
+// the root of the tree, so that the consumer can reconstruct the hierarchy
+// without parsing the paths. The `opts.Format` is ignored.
+func (dt *DirTree) PrintJSONLines(opts PrintOptions) []string {
+	var out []string
+	for _, e := range dt.entries(&opts) {
+		b, err := json.Marshal(jsonEntry{
+			Path:  opts.path(e),
+			Size:  e.size,
+			Depth: e.depth,
+			Dir:   e.dir,
+		})
+		if err != nil {
+			errLog.Println(err)
+			continue
+		}
+		out = append(out, string(b))
+	}
+
+	return out
+}
+
+// A file in the JSON tree.
+type jsonFile struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Bytes int64  `json:"bytes"`
+}
+
+// JSONVersion is the version of the format written by PrintJSON. It is
+// increased whenever the format changes in a way that can break consumers.
+const JSONVersion = 1
+
+// The program that wrote the JSON tree.
+const jsonGenerator = "go-du"
+
+// A directory in the JSON tree. The version, the generator and the unit size
+// are only set on the root.
+type jsonTree struct {
+	Version   int         `json:"version,omitempty"`
+	Generator string      `json:"generator,omitempty"`
+	Path      string      `json:"path"`
+	Size      int64       `json:"size"`
+	Bytes     int64       `json:"bytes"`
+	UnitSize  int64       `json:"unit_size,omitempty"`
+	Files     []jsonFile  `json:"files,omitempty"`
+	Subdirs   []*jsonTree `json:"subdirs,omitempty"`
+}
+
+// PrintJSON returns `dt` as a single JSON object with the nested
+// sub-directories (unless `opts.Summarise` is set) and files (if
+// `opts.CountFiles` is set). The sizes are given both in units and in bytes.
+// The object also has the version of the format and the generator.
+// The `opts.Format` is ignored. The result can be read back with ReadJSON.
+//
+// The object is on a single line, unless `opts.Pretty` is set, then it is
+// indented with two spaces per level.
+//
+// With `opts.Summarise` the object has just the path and the total size of
+// `dt`, for example {"path":"dir","size":8,"bytes":4096}.
+func (dt *DirTree) PrintJSON(opts PrintOptions) []string {
+	jt := dt.toJSON(&opts)
+	if opts.Summarise {
+		jt.Files = nil
+	} else {
+		jt.Version = JSONVersion
+		jt.Generator = jsonGenerator
+		jt.UnitSize = dt.unitSize
+	}
+	var b []byte
+	var err error
+	if opts.Pretty {
+		b, err = json.MarshalIndent(jt, "", "  ")
+	} else {
+		b, err = json.Marshal(jt)
+	}
+	if err != nil {
+		errLog.Println(err)
+		return nil
+	}
+
+	return []string{string(b)}
+}
+
+// WriteJSON writes `dt` to `w` as the same JSON object PrintJSON returns,
+// but without building the whole of it in memory first: the directories are
+// encoded one by one while walking over the tree, so that the memory taken
+// by the output of a huge tree is bounded by its deepest path rather than
+// by its size. The object isn't followed by a newline. `opts.Pretty` is not
+// supported, the object is always on a single line.
+func (dt *DirTree) WriteJSON(w io.Writer, opts PrintOptions) error {
+	bw := bufio.NewWriter(w)
+	if err := dt.writeJSON(bw, &opts, true); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// writeJSON writes `dt` and its content to `w` recursively, see WriteJSON.
+// Only the `root` has the version, the generator and the unit size.
+func (dt *DirTree) writeJSON(w *bufio.Writer, opts *PrintOptions, root bool) error {
+	jt := &jsonTree{
+		Path:  opts.path(entry{path: filepath.Clean(dt.path), virtual: dt.virtual}),
+		Size:  dt.size,
+		Bytes: dt.bytes(dt.size),
+	}
+	if root && !opts.Summarise {
+		jt.Version = JSONVersion
+		jt.Generator = jsonGenerator
+		jt.UnitSize = dt.unitSize
+	}
+	// The files and the sub-directories are the last fields, they are
+	// added in place of the closing brace
+	b, err := json.Marshal(jt)
+	if err != nil {
+		return err
+	}
+	w.Write(bytes.TrimSuffix(b, []byte("}")))
+	if opts.CountFiles && !opts.Summarise && len(dt.files) > 0 {
+		w.WriteString(`,"files":[`)
+		for i, f := range dt.files {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			b, err := json.Marshal(jsonFile{Path: opts.path(entry{path: f.path}), Size: f.size, Bytes: dt.bytes(f.size)})
+			if err != nil {
+				return err
+			}
+			w.Write(b)
+		}
+		w.WriteByte(']')
+	}
+	if !opts.Summarise && len(dt.subdirs) > 0 {
+		w.WriteString(`,"subdirs":[`)
+		for i, d := range dt.subdirs {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			if err := d.writeJSON(w, opts, false); err != nil {
+				return err
+			}
+		}
+		w.WriteByte(']')
+	}
+	// Errors are sticky, the first one is returned by Flush
+	_, err = w.WriteString("}")
+
+	return err
+}
+
+// toJSON converts `dt` to its JSON representation recursively.
+func (dt *DirTree) toJSON(opts *PrintOptions) *jsonTree {
+	jt := &jsonTree{
+		Path:  opts.path(entry{path: filepath.Clean(dt.path), virtual: dt.virtual}),
+		Size:  dt.size,
+		Bytes: dt.bytes(dt.size),
+	}
+	if opts.CountFiles {
+		for _, f := range dt.files {
+			jt.Files = append(jt.Files, jsonFile{Path: opts.path(entry{path: f.path}), Size: f.size, Bytes: dt.bytes(f.size)})
+		}
+	}
+	if !opts.Summarise {
+		for _, d := range dt.subdirs {
+			jt.Subdirs = append(jt.Subdirs, d.toJSON(opts))
+		}
+	}
+
+	return jt
+}
+
+// ReadJSON reads the trees written out by PrintJSON from `r`, one after
+// another until the end of it. Trees written in a newer version of the format
+// (see JSONVersion) are rejected.
+func ReadJSON(r io.Reader) ([]*DirTree, error) {
+	var trees []*DirTree
+	dec := json.NewDecoder(r)
+	for {
+		var jt jsonTree
+		if err := dec.Decode(&jt); err == io.EOF {
+			return trees, nil
+		} else if err != nil {
+			return trees, err
+		}
+		if jt.Version > JSONVersion {
+			return trees, fmt.Errorf("unsupported format version %d, expecting %d or older", jt.Version, JSONVersion)
+		}
+		// The totals written with Summarise don't have the unit size,
+		// but it is the number of bytes in a unit
+		unitSize := jt.UnitSize
+		if unitSize <= 0 && jt.Size > 0 {
+			unitSize = jt.Bytes / jt.Size
+		}
+		if unitSize <= 0 {
+			unitSize = 512
+		}
+		trees = append(trees, jt.toDirTree(unitSize, 0))
+	}
+}
+
+// toDirTree converts a JSON representation of a tree back to a DirTree.
+func (jt *jsonTree) toDirTree(unitSize int64, depth int) *DirTree {
+	dt := &DirTree{
+		path:      jt.Path,
+		size:      jt.Size,
+		depth:     depth,
+		unitSize:  unitSize,
+		blockSize: defaultBlockSize,
+	}
+	for _, f := range jt.Files {
+		dt.files = append(dt.files, FileInfo{path: f.Path, size: f.Size, depth: depth + 1})
+	}
+	for _, d := range jt.Subdirs {
+		dt.subdirs = append(dt.subdirs, d.toDirTree(unitSize, depth+1))
+	}
+
+	return dt
+}
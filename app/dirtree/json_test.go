@@ -0,0 +1,228 @@
+package dirtree
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_PrintJSONLines(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	out := dt.PrintJSONLines(PrintOptions{CountFiles: true})
+	want := []jsonEntry{
+		{Path: testFilesRoot + "/under_4k.txt", Size: 8, Depth: 1},
+		{Path: testFilesRoot + "/subdir/over_4m.txt", Size: 11360, Depth: 2},
+		{Path: testFilesRoot + "/subdir", Size: 11368, Depth: 1, Dir: true},
+		{Path: testFilesRoot, Size: 11384, Depth: 0, Dir: true},
+	}
+	if len(out) != len(want) {
+		t.Fatalf("Expecting %d lines and not %d: %q", len(want), len(out), out)
+	}
+	for i, v := range out {
+		var got jsonEntry
+		if err := json.Unmarshal([]byte(v), &got); err != nil {
+			t.Fatalf("Output string #%v %q is not valid JSON: %v", i, v, err)
+		}
+		if got != want[i] {
+			t.Errorf("Output entry #%v %+v is not equal to the expected one %+v", i, got, want[i])
+		}
+	}
+}
+
+func Test_PrintJSON(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 1024)
+	opts := PrintOptions{Format: "%d\t%s", CountFiles: true}
+	out := dt.PrintJSON(opts)
+	if len(out) != 1 {
+		t.Fatalf("Expecting a single line and not %q", out)
+	}
+	want := `{"version":1,"generator":"go-du","path":"` + testFilesRoot + `","size":5692,"bytes":5828608,"unit_size":1024,` +
+		`"files":[{"path":"` + testFilesRoot + `/under_4k.txt","size":4,"bytes":4096}],` +
+		`"subdirs":[{"path":"` + testFilesRoot + `/subdir","size":5684,"bytes":5820416,` +
+		`"files":[{"path":"` + testFilesRoot + `/subdir/over_4m.txt","size":5680,"bytes":5816320}]}]}`
+	if out[0] != want {
+		t.Errorf("Expecting JSON\n%s\nand not\n%s", want, out[0])
+	}
+
+	// Reading it back gives the same tree
+	trees, err := ReadJSON(strings.NewReader(out[0]))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(trees) != 1 {
+		t.Fatalf("Expecting a single tree and not %d", len(trees))
+	}
+	got, exp := trees[0].Print(opts), dt.Print(opts)
+	if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("Expecting the tree read back to be printed as %q and not %q", exp, got)
+	}
+}
+
+func Test_WriteJSON(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "a", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "a", "b", "c", "exactly_4k.txt"), 4096},
+		{filepath.Join(testFilesRoot, "d", "under_4k.txt"), 1234},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 1024)
+	for _, opts := range []PrintOptions{{CountFiles: true}, {}, {Summarise: true}, {CountFiles: true, Summarise: true}} {
+		var b strings.Builder
+		if err := dt.WriteJSON(&b, opts); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		want := dt.PrintJSON(opts)[0]
+		if b.String() != want {
+			t.Errorf("Expecting the streamed JSON with %+v\n%s\nand not\n%s", opts, want, b.String())
+		}
+		var got, exp jsonTree
+		if err := json.Unmarshal([]byte(b.String()), &got); err != nil {
+			t.Fatalf("Streamed JSON %q is not valid: %v", b.String(), err)
+		}
+		if err := json.Unmarshal([]byte(want), &exp); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("Expecting the streamed tree %+v and not %+v", exp, got)
+		}
+	}
+
+	// The write errors are returned
+	if err := dt.WriteJSON(failingWriter{}, PrintOptions{CountFiles: true}); err != errWrite {
+		t.Errorf("Expecting %v and not %v", errWrite, err)
+	}
+}
+
+// The error of failingWriter.
+var errWrite = errors.New("write failed")
+
+// failingWriter fails all the writes.
+type failingWriter struct{}
+
+// Write implements io.Writer.
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errWrite
+}
+
+func Test_PrintJSONPretty(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "subdir", "under_4k.txt"), 3456},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 1024)
+	compact := dt.PrintJSON(PrintOptions{CountFiles: true})
+	if len(compact) != 1 || strings.Contains(compact[0], "\n") || strings.Contains(compact[0], "  ") {
+		t.Errorf("Expecting compact JSON on a single line and not %q", compact)
+	}
+	pretty := dt.PrintJSON(PrintOptions{CountFiles: true, Pretty: true})
+	if len(pretty) != 1 || !strings.Contains(pretty[0], "\n  \"subdirs\": [\n    {\n      \"path\"") {
+		t.Errorf("Expecting indented JSON and not %q", pretty)
+	}
+
+	// Both read back the same
+	a, err := ReadJSON(strings.NewReader(compact[0]))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	b, err := ReadJSON(strings.NewReader(pretty[0]))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("Expecting the same trees from compact and pretty JSON")
+	}
+}
+
+func Test_PrintJSONSummarise(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 1024)
+	out := dt.PrintJSON(PrintOptions{Summarise: true})
+	want := `{"path":"` + testFilesRoot + `","size":5692,"bytes":5828608}`
+	if len(out) != 1 || out[0] != want {
+		t.Fatalf("Expecting a single total %q and not %q", want, out)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(out[0]), &doc); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := doc["subdirs"]; ok {
+		t.Errorf("Expecting no subdirs in the total and not %q", out[0])
+	}
+
+	// The unit size is worked out when it is read back
+	trees, err := ReadJSON(strings.NewReader(out[0]))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(trees) != 1 || trees[0].size != 5692 || trees[0].unitSize != 1024 {
+		t.Errorf("Expecting the total of 5692 units of 1024 bytes and not %+v", trees)
+	}
+}
+
+func Test_JSONVersion(t *testing.T) {
+	if err := createTestData([]testFile{{filepath.Join(testFilesRoot, "under_4k.txt"), 3456}}); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	out := New(testFilesRoot, 512).PrintJSON(PrintOptions{})
+	var doc struct {
+		Version   int    `json:"version"`
+		Generator string `json:"generator"`
+	}
+	if err := json.Unmarshal([]byte(out[0]), &doc); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if doc.Version != JSONVersion || doc.Generator != "go-du" {
+		t.Errorf("Expecting version %d by go-du and not %d by %q", JSONVersion, doc.Version, doc.Generator)
+	}
+
+	// A newer version cannot be read
+	newer := `{"version":` + strconv.Itoa(JSONVersion+1) + `,"path":"dir","size":8}`
+	if _, err := ReadJSON(strings.NewReader(newer)); err == nil {
+		t.Errorf("Expecting an error for a newer version")
+	}
+	// The trees written before the version was added can still be read
+	if _, err := ReadJSON(strings.NewReader(`{"path":"dir","size":8}`)); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
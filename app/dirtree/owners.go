@@ -0,0 +1,116 @@
+package dirtree
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"syscall"
+)
+
+// addOwner adds `size` units to the totals of the owner and the group of the
+// file or directory described by `info`, if they are counted, see
+// Scanner.ByOwner and Scanner.ByGroup.
+func (dt *DirTree) addOwner(info os.FileInfo, size int64) {
+	if (dt.owners == nil && dt.groups == nil) || size == 0 {
+		return
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	if dt.owners != nil {
+		dt.owners[st.Uid] = dt.owners[st.Uid] + size
+	}
+	if dt.groups != nil {
+		dt.groups[st.Gid] = dt.groups[st.Gid] + size
+	}
+}
+
+// mergeOwners adds the totals of the owners and the groups of `sub` to the
+// ones of `dt`.
+func (dt *DirTree) mergeOwners(sub *DirTree) {
+	dt.owners = mergeTotals(dt.owners, sub.owners)
+	dt.groups = mergeTotals(dt.groups, sub.groups)
+}
+
+// mergeTotals adds the totals of `from` to `to` and returns it, creating it
+// if needed.
+func mergeTotals(to, from map[uint32]int64) map[uint32]int64 {
+	if from == nil {
+		return to
+	}
+	if to == nil {
+		to = make(map[uint32]int64)
+	}
+	for id, size := range from {
+		to[id] = to[id] + size
+	}
+
+	return to
+}
+
+// lookupUser and lookupGroup find a user and a group by the id, they are
+// replaced in tests.
+var (
+	lookupUser  = user.LookupId
+	lookupGroup = user.LookupGroupId
+)
+
+// ownerName returns the name of the user `uid`, or the number itself if
+// there is no such user.
+func ownerName(uid uint32) string {
+	id := strconv.FormatUint(uint64(uid), 10)
+	if u, err := lookupUser(id); err == nil {
+		return u.Username
+	}
+
+	return id
+}
+
+// groupName returns the name of the group `gid`, or the number itself if
+// there is no such group.
+func groupName(gid uint32) string {
+	id := strconv.FormatUint(uint64(gid), 10)
+	if g, err := lookupGroup(id); err == nil {
+		return g.Name
+	}
+
+	return id
+}
+
+// PrintOwners returns a line for each owner of the files and directories in
+// `dt` with the total size of what they own, separated by a tab, for example
+// "alice\t1547", the biggest first. The owners are only known if the scanner
+// counted them, see Scanner.ByOwner. The `opts.Format` is ignored.
+func (dt *DirTree) PrintOwners(opts PrintOptions) []string {
+	return dt.printTotals(dt.owners, ownerName, &opts)
+}
+
+// PrintGroups is like PrintOwners, but for the groups of the files and
+// directories, see Scanner.ByGroup.
+func (dt *DirTree) PrintGroups(opts PrintOptions) []string {
+	return dt.printTotals(dt.groups, groupName, &opts)
+}
+
+// printTotals returns a line for each id in `totals` with its name and
+// total size, the biggest first.
+func (dt *DirTree) printTotals(totals map[uint32]int64, name func(uint32) string, opts *PrintOptions) []string {
+	ids := make([]uint32, 0, len(totals))
+	for id := range totals {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if a, b := totals[ids[i]], totals[ids[j]]; a != b {
+			return a > b
+		}
+		return ids[i] < ids[j]
+	})
+	var out []string
+	for _, id := range ids {
+		out = append(out, fmt.Sprintf("%s\t%d", name(id), dt.sizeField(totals[id], opts)))
+	}
+
+	return out
+}
@@ -0,0 +1,138 @@
+package dirtree
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// numericOwners makes the owners printed by their user ids, so that the
+// tests don't depend on the users of the system. The returned function
+// restores the real lookup.
+func numericOwners() func() {
+	lookupUser = func(string) (*user.User, error) { return nil, errors.New("unknown user") }
+	lookupGroup = func(string) (*user.Group, error) { return nil, errors.New("unknown group") }
+	return func() {
+		lookupUser = user.LookupId
+		lookupGroup = user.LookupGroupId
+	}
+}
+
+func Test_PrintOwners(t *testing.T) {
+	defer numericOwners()()
+	dt := &DirTree{unitSize: 512, owners: map[uint32]int64{1000: 8, 0: 16, 1001: 8}}
+	got := dt.PrintOwners(PrintOptions{})
+	want := []string{"0\t16", "1000\t8", "1001\t8"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting %q and not %q", want, got)
+	}
+}
+
+func Test_ScannerByOwner(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	defer numericOwners()()
+
+	s := NewScanner(512)
+	s.ByOwner = true
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Everything belongs to the user running the tests
+	want := []string{strconv.Itoa(os.Getuid()) + "\t" + strconv.FormatInt(dt.size, 10)}
+	if got := dt.PrintOwners(PrintOptions{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting %q and not %q", want, got)
+	}
+	if got := Combine("total", dt, dt).PrintOwners(PrintOptions{}); len(got) != 1 || got[0] != strconv.Itoa(os.Getuid())+"\t"+strconv.FormatInt(2*dt.size, 10) {
+		t.Errorf("Expecting the combined owners to add up and not %q", got)
+	}
+
+	// Without ByOwner there are no owners
+	if got := New(testFilesRoot, 512).PrintOwners(PrintOptions{}); len(got) != 0 {
+		t.Errorf("Expecting no owners and not %q", got)
+	}
+}
+
+// groupInfo is a file that pretends to belong to the group `gid`.
+type groupInfo struct {
+	os.FileInfo
+	gid uint32
+}
+
+func (i groupInfo) Sys() interface{} {
+	st := *i.FileInfo.Sys().(*syscall.Stat_t)
+	st.Gid = i.gid
+	return &st
+}
+
+// groupEntry is a directory entry of a file that pretends to belong to the
+// group `gid`.
+type groupEntry struct {
+	os.DirEntry
+	gid uint32
+}
+
+func (e groupEntry) Info() (os.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	return groupInfo{info, e.gid}, err
+}
+
+func Test_ScannerByGroup(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "a.txt"), 3456},
+		{filepath.Join(testFilesRoot, "b.txt"), 5678},
+		{filepath.Join(testFilesRoot, "subdir", "c.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	defer numericOwners()()
+	gids := map[string]uint32{"a.txt": 100, "b.txt": 200, "c.txt": 100}
+	readDir = func(name string) ([]os.DirEntry, error) {
+		entries, err := os.ReadDir(name)
+		for i, e := range entries {
+			if gid, ok := gids[e.Name()]; ok {
+				entries[i] = groupEntry{e, gid}
+			}
+		}
+		return entries, err
+	}
+	defer func() { readDir = os.ReadDir }()
+
+	s := NewScanner(512)
+	s.ByGroup = true
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The directories belong to the group of the user running the tests
+	dirs := dt.size - dt.calcSize(3456) - 2*dt.calcSize(5678)
+	want := map[uint32]int64{
+		100:                 dt.calcSize(3456) + dt.calcSize(5678),
+		200:                 dt.calcSize(5678),
+		uint32(os.Getgid()): dirs,
+	}
+	if !reflect.DeepEqual(dt.groups, want) {
+		t.Errorf("Expecting the totals by group %v and not %v", want, dt.groups)
+	}
+	if dt.owners != nil {
+		t.Errorf("Expecting no owners without ByOwner and not %v", dt.owners)
+	}
+	got := dt.PrintGroups(PrintOptions{})
+	if len(got) != 3 || got[0] != "100\t"+strconv.FormatInt(want[100], 10) {
+		t.Errorf("Expecting the biggest group 100 first and not %q", got)
+	}
+}
@@ -0,0 +1,44 @@
+package dirtree
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// The filesystem calls that are retried, see Scanner.Retry. They are
+// variables so that the tests can make them fail.
+var (
+	readDir = os.ReadDir
+	lstat   = os.Lstat
+)
+
+// How long to wait before the first retry, the wait doubles after each one.
+var retryBackoff = 100 * time.Millisecond
+
+// retry calls `op` again while `err`, the error of the previous call, is
+// transient, up to Retry times. Returns the error of the last call or nil
+// once `op` succeeds.
+func (s *Scanner) retry(err error, op func() error) error {
+	wait := retryBackoff
+	for i := 0; i < s.Retry && err != nil && transient(err) && !s.canceled(); i++ {
+		time.Sleep(wait)
+		wait *= 2
+		err = op()
+	}
+
+	return err
+}
+
+// transient reports whether `err` may go away if the call is retried, like
+// the I/O errors and timeouts of network filesystems.
+func transient(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EIO, syscall.ETIMEDOUT, syscall.EAGAIN, syscall.EINTR} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,64 @@
+package dirtree
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// failReadDir makes reading the directory `path` fail with EIO `n` times
+// before it succeeds. The returned function restores the real os.ReadDir.
+func failReadDir(path string, n int) func() {
+	readDir = func(name string) ([]os.DirEntry, error) {
+		if name == path && n > 0 {
+			n--
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EIO}
+		}
+		return os.ReadDir(name)
+	}
+	return func() { readDir = os.ReadDir }
+}
+
+func Test_ScannerRetry(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	errLog.SetOutput(ioutil.Discard)
+	defer errLog.SetOutput(os.Stderr)
+	defer func(d time.Duration) { retryBackoff = d }(retryBackoff)
+	retryBackoff = time.Millisecond
+	want := New(testFilesRoot, 512)
+
+	// Without retries the sub-directory is lost
+	defer failReadDir(filepath.Join(testFilesRoot, "subdir"), 1)()
+	s := NewScanner(512)
+	dt, _ := s.Scan(testFilesRoot)
+	if !s.Failed() || dt.size == want.size {
+		t.Errorf("Expecting the sub-directory to fail without retries")
+	}
+
+	// The second attempt succeeds
+	failReadDir(filepath.Join(testFilesRoot, "subdir"), 1)
+	s = NewScanner(512)
+	s.Retry = 2
+	dt, _ = s.Scan(testFilesRoot)
+	if s.Failed() {
+		t.Errorf("Unexpected errors: %v", s.Errors())
+	}
+	if dt.size != want.size || dt.fileCount != want.fileCount {
+		t.Errorf("Expecting size %d of %d files and not %d of %d", want.size, want.fileCount, dt.size, dt.fileCount)
+	}
+
+	// Permanent errors are not retried
+	if transient(&os.PathError{Op: "open", Path: "dir", Err: syscall.EACCES}) {
+		t.Errorf("Expecting EACCES not to be transient")
+	}
+}
@@ -0,0 +1,506 @@
+package dirtree
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Filesystem block size used when the real one cannot be determined.
+const defaultBlockSize = 4096
+
+// DerefMode tells the scanner which symbolic links to follow. A symbolic
+// link that is not followed is counted as a file.
+type DerefMode int
+
+const (
+	// DerefNone doesn't follow any symbolic links, this is the default.
+	DerefNone DerefMode = iota
+	// DerefArgs follows the root of a scan if it is a symbolic link, but
+	// no other links under it, like cp -H does with its operands. The
+	// links found during the scan are counted as files even if they point
+	// to directories.
+	DerefArgs
+	// DerefAll follows all symbolic links.
+	DerefAll
+)
+
+// The error reported when following a symbolic link leads to one of its
+// parent directories.
+var errCycle = errors.New("circular directory structure")
+
+// A file identity - a device and an inode number on it.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// Scanner builds directory trees.
+//
+// It remembers files with multiple hard links it has already counted and
+// the block sizes of the filesystems it has visited. Both are kept between
+// calls to Scan so a program that repeatedly scans the same hierarchy
+// doesn't have to query the filesystems again and again.
+type Scanner struct {
+	// Unit size used for displaying, see DirTree.
+	UnitSize int64
+	// How sizes are rounded to units, rounded up by default.
+	Rounding RoundingMode
+	// Use the fundamental block size of the filesystems (f_frsize) rather
+	// than the optimal transfer block size (f_bsize) to calculate the space
+	// allocated to the files, see getFSBlockSize.
+	UseFrsize bool
+	// Count the apparent sizes of the files and directories (st_size, the
+	// number of bytes in a file) instead of the space allocated for them.
+	ApparentSize bool
+	// Count the space allocated to the files and directories exactly as the
+	// filesystem reports it (st_blocks * 512) instead of estimating it from
+	// their sizes and the block size of the filesystem. With the unit size
+	// of 1 the sizes are the exact numbers of bytes in use.
+	ExactAllocated bool
+	// Count the apparent sizes of the regular files smaller than a block
+	// instead of a whole block for each, and the allocated sizes of the
+	// bigger ones, to approximate the filesystems that pack the tails of
+	// the files together. A tree of tiny files like a maildir is hugely
+	// over-reported otherwise.
+	TailPacking bool
+	// If greater than 0, add DentryOverhead bytes for each entry in a
+	// directory to the size of the directory, as a rough estimate of the
+	// space taken by the names of the entries beyond its st_size. This is
+	// experimental.
+	DentryOverhead int64
+	// Count only the space allocated to the regular files, leaving out the
+	// directories themselves, the symbolic links and the special files, so
+	// that the sizes are what a backup of the content would take.
+	RegularFilesOnly bool
+	// If set, count only the files modified after ChangedSince, e.g. to
+	// find out how big an incremental backup would be. The directories are
+	// still scanned and counted.
+	ChangedSince time.Time
+	// If set, count only the files modified between ModifiedFrom and
+	// ModifiedTo, both inclusive. Either of them can be left out for a
+	// range open on that side. The directories are still scanned and
+	// counted.
+	ModifiedFrom, ModifiedTo time.Time
+	// Count the extents shared by several files, e.g. the reflink copies
+	// on Btrfs or XFS, only once, for the first file they are found in,
+	// like the files with multiple hard links. The extents are looked up
+	// with the FIEMAP ioctl, which is only supported by the builds with
+	// the fiemap tag, see DedupExtentsSupported. This is experimental and
+	// much slower than a normal scan.
+	DedupExtents bool
+	// Add up the sizes of the files and directories by their owners and
+	// by their groups as well, see DirTree.PrintOwners and
+	// DirTree.PrintGroups.
+	ByOwner, ByGroup bool
+	// Remember the regular files with no data in them, see
+	// DirTree.PrintZeroByteFiles.
+	ZeroByteFiles bool
+	// Skip the directories on other filesystems than the one they are
+	// found on, like /proc or /sys under /, as GNU du -x does. The mount
+	// points themselves are left out as well.
+	OneFileSystem bool
+	// Which symbolic links to follow.
+	Dereference DerefMode
+	// If greater than 0, DerefAll follows only the symbolic links found at
+	// most DerefDepth levels below the root of a scan, the deeper ones are
+	// counted as files. The root itself is followed in any case.
+	DerefDepth int
+	// Use the real paths the followed symbolic links resolve to (see
+	// filepath.EvalSymlinks) instead of the paths of the links, so that it
+	// is clear where the counted bytes actually are.
+	RealPaths bool
+	// Shell patterns (see filepath.Match) of files and directories to skip.
+	// A pattern is matched against both the name and the path of an entry.
+	// The root of a scan is never excluded, so that its total is always
+	// there.
+	Exclude []string
+	// Skip the files and directories ignored by the .gitignore files found
+	// during the traversal.
+	GitIgnore bool
+	// Skip the hidden files and directories, the ones with the name that
+	// starts with a dot.
+	ExcludeDotfiles bool
+	// Skip everything but the hidden files and directories and the content
+	// of the hidden directories, the opposite of ExcludeDotfiles.
+	OnlyDotfiles bool
+	// Only add up the sizes of the files without keeping them in the tree.
+	// This saves memory on huge directories when the files are not going
+	// to be printed.
+	SkipFiles bool
+	// If greater than 0, read at most about Throttle directories per second
+	// to limit the load on the storage shared with other workloads.
+	Throttle int
+	// Retry reading a directory or a file up to Retry times if it fails
+	// with an error that may go away, such as EIO or ETIMEDOUT on a network
+	// filesystem, waiting longer before each retry.
+	Retry int
+	// If greater than 0, give up reading a directory or a file after
+	// OpTimeout, reporting and skipping it like the ones that cannot be
+	// read, so that a single hung path on a network filesystem doesn't
+	// stall the whole scan. The error wraps os.ErrDeadlineExceeded.
+	OpTimeout time.Duration
+	// Stop the scan at the first error instead of reporting it and going
+	// on, the error is returned by Scan then.
+	FailFast bool
+	// Print errors with the path that caused them in the style of GNU du
+	// instead of the bare system errors, see ScanError.
+	VerboseErrors bool
+	// If set, Progress is called during a scan with the percentage of the
+	// entries scanned so far. To know how many entries there are the scan
+	// is preceded by a quick count that only reads the directories, the
+	// percentage is therefore approximate if the files change in between.
+	Progress func(percent float64)
+	// If set, FilesDone is called for each directory as soon as the sizes
+	// of its files are known, before its sub-directories are scanned, and
+	// DirDone once the directory has been scanned completely. This allows
+	// printing a tree in the same order as DirTree.Print does while it is
+	// still being scanned, see DirTree.PrintFiles and DirTree.PrintDir.
+	FilesDone, DirDone func(dt *DirTree)
+	// The number of entries expected and scanned so far for Progress.
+	total, done int
+	// Files with more than one hard link that were already counted.
+	inodes map[fileID]bool
+	// The lengths of the shared extents already counted, see DedupExtents.
+	extents map[extentID]uint64
+	// Filesystem block sizes by device.
+	blockSizes map[uint64]int64
+	// Directories from the root of the scan to the current one, used to
+	// detect cycles when following symbolic links.
+	parents map[fileID]bool
+	// The rules from the .gitignore files in the parent directories.
+	ignore []ignoreRule
+	// The errors reported so far, see Errors.
+	errs []*ScanError
+	// The context of the current scan, see ScanContext, and the function
+	// that stops the scan with FailFast.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// When the last directory was read, for Throttle.
+	lastRead time.Time
+	// Don't print the errors out, see Verify.
+	quiet bool
+	// A size has been capped during the current scan, see addSize.
+	overflow bool
+}
+
+// VCSPatterns are the exclude patterns that match the metadata directories
+// of the common version control systems, like tar --exclude-vcs does.
+var VCSPatterns = []string{".git", ".svn", ".hg", ".bzr", "CVS"}
+
+// NewScanner creates a new scanner that reports sizes in units of
+// `unitSize` bytes.
+func NewScanner(unitSize int64) *Scanner {
+	return &Scanner{
+		UnitSize:   unitSize,
+		inodes:     make(map[fileID]bool),
+		extents:    make(map[extentID]uint64),
+		blockSizes: make(map[uint64]int64),
+		parents:    make(map[fileID]bool),
+	}
+}
+
+// Scan creates a new directory tree rooted at `path`.
+//
+// All errors are printed to stderr. Errors encountered during the traversal
+// do not cause Scan to fail. But if `path` itself cannot be accessed a
+// *ScanError is returned as well, the returned tree is empty in this case.
+func (s *Scanner) Scan(path string) (*DirTree, error) {
+	return s.ScanContext(context.Background(), path)
+}
+
+// ScanContext is like Scan but stops as soon as `ctx` is done. In this case
+// the tree scanned so far is returned together with the error of `ctx`, the
+// sizes in it are incomplete. The same goes for the first error with
+// FailFast.
+func (s *Scanner) ScanContext(ctx context.Context, path string) (*DirTree, error) {
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	defer func() {
+		s.cancel()
+		s.ctx, s.cancel = nil, nil
+	}()
+	failed := len(s.errs)
+	s.overflow = false
+	dt := &DirTree{path: path, unitSize: s.UnitSize, rounding: s.Rounding, apparent: s.ApparentSize, exact: s.ExactAllocated, tailPacking: s.TailPacking, blockSize: defaultBlockSize}
+	if err := ctx.Err(); err != nil {
+		return dt, err
+	}
+	stat := lstat
+	if s.Dereference != DerefNone {
+		stat = os.Stat
+	}
+	info, err := s.statTimeout(stat, path)
+	err = s.retry(err, func() (err error) {
+		info, err = s.statTimeout(stat, path)
+		return err
+	})
+	if err != nil {
+		serr := &ScanError{Op: "access", Path: path, Err: err}
+		s.report(serr)
+		return dt, serr
+	}
+	if s.Dereference != DerefNone {
+		if link, err := os.Lstat(path); err == nil && link.Mode()&os.ModeSymlink != 0 {
+			dt.path = s.realPath(path)
+		}
+	}
+	if s.Progress != nil {
+		s.total, s.done = 1, 0
+		if info.IsDir() {
+			s.total += s.count(path, info)
+		}
+		s.step()
+	}
+	dt.buildDirTree(s, info)
+	if s.FailFast && len(s.errs) > failed {
+		return dt, s.errs[failed]
+	}
+
+	return dt, ctx.Err()
+}
+
+// canceled reports whether the current scan should stop.
+func (s *Scanner) canceled() bool {
+	return s.ctx != nil && s.ctx.Err() != nil
+}
+
+// Reset forgets the hard linked files and the shared extents counted so far,
+// so that the next scan counts them again. The block sizes cache is kept.
+func (s *Scanner) Reset() {
+	s.inodes = make(map[fileID]bool)
+	s.extents = make(map[extentID]uint64)
+}
+
+// dirDone reports that `dt` has been scanned completely.
+func (s *Scanner) dirDone(dt *DirTree) {
+	if s.DirDone != nil {
+		s.DirDone(dt)
+	}
+}
+
+// count returns the number of entries in the directory `path` described by
+// `info` and all its sub-directories. It only reads the directories without
+// calling stat() on the entries other than the sub-directories. The
+// directories that cannot be read, or not within OpTimeout, are given up on
+// without retrying, the scan reports them. Like buildDirTree, it doesn't
+// cross to other file systems with OneFileSystem, so that they are not read
+// just to be counted.
+func (s *Scanner) count(path string, info os.FileInfo) int {
+	defer s.readIgnoreRules(path)()
+	s.throttle()
+	entries, err := s.readDirTimeout(path)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, e := range entries {
+		if s.canceled() {
+			break
+		}
+		p := filepath.Join(path, e.Name())
+		if s.skip(p, e.IsDir()) {
+			continue
+		}
+		n++
+		if !e.IsDir() {
+			continue
+		}
+		sub, err := s.infoTimeout(e, p)
+		if err != nil || (s.OneFileSystem && mountPoint(info, sub)) {
+			continue
+		}
+		n += s.count(p, sub)
+	}
+
+	return n
+}
+
+// throttle waits until the next directory can be read according to
+// Throttle.
+func (s *Scanner) throttle() {
+	if s.Throttle <= 0 {
+		return
+	}
+	interval := time.Second / time.Duration(s.Throttle)
+	if wait := time.Until(s.lastRead.Add(interval)); wait > 0 {
+		time.Sleep(wait)
+	}
+	s.lastRead = time.Now()
+}
+
+// step reports the progress after one more entry has been scanned.
+func (s *Scanner) step() {
+	if s.Progress == nil {
+		return
+	}
+	s.done++
+	percent := 100 * float64(s.done) / float64(s.total)
+	if percent > 100 {
+		percent = 100
+	}
+	s.Progress(percent)
+}
+
+// skip reports whether the file or directory at `path` should be skipped,
+// because it is excluded, hidden or ignored by a .gitignore file.
+func (s *Scanner) skip(path string, dir bool) bool {
+	if s.ExcludeDotfiles && strings.HasPrefix(filepath.Base(path), ".") {
+		return true
+	}
+	if s.OnlyDotfiles && !hidden(path) {
+		return true
+	}
+	return s.excluded(path) || (s.GitIgnore && s.gitignored(path, dir))
+}
+
+// hidden reports whether the file at `path` or one of the directories it is
+// in is hidden.
+func hidden(path string) bool {
+	for _, name := range strings.Split(path, string(filepath.Separator)) {
+		if strings.HasPrefix(name, ".") && name != "." && name != ".." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// excluded reports whether the entry at `path` matches one of the exclude
+// patterns.
+func (s *Scanner) excluded(path string) bool {
+	name := filepath.Base(path)
+	for _, p := range s.Exclude {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, path); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// follow returns the file `path` points to if it is a symbolic link that
+// should be followed at `depth` levels below the root of the scan, together
+// with the path to use for it, see RealPaths. Otherwise, or if the link is
+// dangling, returns `path` and `info`, the link itself.
+func (s *Scanner) follow(path string, info os.FileInfo, depth int) (string, os.FileInfo) {
+	if s.Dereference != DerefAll || info.Mode()&os.ModeSymlink == 0 {
+		return path, info
+	}
+	if s.DerefDepth > 0 && depth > s.DerefDepth {
+		return path, info
+	}
+	target, err := os.Stat(path)
+	if err != nil {
+		return path, info
+	}
+
+	return s.realPath(path), target
+}
+
+// realPath returns the path the symbolic link at `path` resolves to if
+// RealPaths is set and `path` otherwise.
+func (s *Scanner) realPath(path string) string {
+	if !s.RealPaths {
+		return path
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+
+	return real
+}
+
+// enter marks the directory described by `info` as being scanned. Returns
+// false if it is already being scanned, i.e. it is one of the parents of the
+// current directory. The caller should call the returned function when done
+// with the directory.
+func (s *Scanner) enter(info os.FileInfo) (bool, func()) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true, func() {}
+	}
+	id := fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+	if s.parents[id] {
+		return false, func() {}
+	}
+	s.parents[id] = true
+
+	return true, func() { delete(s.parents, id) }
+}
+
+// seen reports whether the file described by `info` has more than one hard
+// link and was already counted. The first time it is called for such a file
+// it remembers it and returns false.
+func (s *Scanner) seen(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return false
+	}
+	id := fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+	if s.inodes[id] {
+		return true
+	}
+	s.inodes[id] = true
+
+	return false
+}
+
+// countable reports whether the file (not a directory) described by `info`
+// should be counted, see RegularFilesOnly, ChangedSince and ModifiedFrom.
+func (s *Scanner) countable(info os.FileInfo) bool {
+	if s.RegularFilesOnly && !info.Mode().IsRegular() {
+		return false
+	}
+	if !s.ChangedSince.IsZero() && !info.ModTime().After(s.ChangedSince) {
+		return false
+	}
+	if !s.ModifiedFrom.IsZero() && info.ModTime().Before(s.ModifiedFrom) {
+		return false
+	}
+	if !s.ModifiedTo.IsZero() && info.ModTime().After(s.ModifiedTo) {
+		return false
+	}
+
+	return true
+}
+
+// mountPoint reports whether the directory described by `info` is on a
+// different device than its parent described by `parent`.
+func mountPoint(parent, info os.FileInfo) bool {
+	pst, ok := parent.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+
+	return ok && st.Dev != pst.Dev
+}
+
+// blockSize returns the block size of the filesystem `path` is on. The
+// `info` is the result of stat() on `path`, it is used to look up the
+// cached value for the device.
+func (s *Scanner) blockSize(path string, info os.FileInfo) int64 {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return defaultBlockSize
+	}
+	dev := uint64(st.Dev)
+	if bs, ok := s.blockSizes[dev]; ok {
+		return bs
+	}
+	bs, err := getFSBlockSize(path, s.UseFrsize)
+	if err != nil {
+		bs = defaultBlockSize
+	}
+	s.blockSizes[dev] = bs
+
+	return bs
+}
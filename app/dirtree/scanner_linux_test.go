@@ -0,0 +1,155 @@
+//go:build linux
+// +build linux
+
+package dirtree
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// devInfo is a directory on the device `dev`.
+type devInfo struct {
+	os.FileInfo
+	dev uint64
+}
+
+func (i devInfo) Sys() interface{} {
+	return &syscall.Stat_t{Dev: i.dev}
+}
+
+func Test_ScannerMountPoints(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "subdir", "under_4k.txt"), 3456},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	info, err := os.Stat(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if mountPoint(devInfo{info, 1}, devInfo{info, 1}) {
+		t.Errorf("Expecting a directory on the same device not to be a mount point")
+	}
+	if !mountPoint(devInfo{info, 1}, devInfo{info, 2}) {
+		t.Errorf("Expecting a directory on another device to be a mount point")
+	}
+
+	// Pretend the root is on another device than its sub-directory
+	dt := &DirTree{path: testFilesRoot, unitSize: 512}
+	dt.buildDirTree(NewScanner(512), devInfo{info, 1})
+	out := dt.Print(PrintOptions{Format: "%d\t%s", Mounts: true})
+	want := []string{"16\t" + testFilesRoot + "/subdir [mount]", "24\t" + testFilesRoot}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("Expecting %q and not %q", want, out)
+	}
+	if out := dt.Print(PrintOptions{Format: "%d\t%s"}); strings.Contains(strings.Join(out, ""), mountMarker) {
+		t.Errorf("Expecting no mount points marked without Mounts and not %q", out)
+	}
+}
+
+// mountInfo is a directory that pretends to be on the device `dev`.
+type mountInfo struct {
+	os.FileInfo
+	dev uint64
+}
+
+func (i mountInfo) Sys() interface{} {
+	st := *i.FileInfo.Sys().(*syscall.Stat_t)
+	st.Dev = i.dev
+	return &st
+}
+
+// mountEntry is a directory entry of a directory that pretends to be a
+// mount point of the device `dev`.
+type mountEntry struct {
+	os.DirEntry
+	dev uint64
+}
+
+func (e mountEntry) Info() (os.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	return mountInfo{info, e.dev}, err
+}
+
+func Test_ScannerOneFileSystem(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "proc", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	// "proc" is a pseudo-filesystem mounted in the tree
+	readDir = func(name string) ([]os.DirEntry, error) {
+		entries, err := os.ReadDir(name)
+		for i, e := range entries {
+			if e.Name() == "proc" {
+				entries[i] = mountEntry{e, 12345}
+			}
+		}
+		return entries, err
+	}
+	defer func() { readDir = os.ReadDir }()
+
+	s := NewScanner(512)
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dt.subdirs) != 1 || dt.size != 40 {
+		t.Errorf("Expecting the mount point to be scanned and not %d directories of %d", len(dt.subdirs), dt.size)
+	}
+	s.OneFileSystem = true
+	dt, err = s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dt.subdirs) != 0 || dt.size != 16 {
+		t.Errorf("Expecting the mount point to be skipped and not %d directories of %d", len(dt.subdirs), dt.size)
+	}
+}
+
+func Test_ScannerProgressOneFileSystem(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "proc", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	readDir = func(name string) ([]os.DirEntry, error) {
+		entries, err := os.ReadDir(name)
+		for i, e := range entries {
+			if e.Name() == "proc" {
+				entries[i] = mountEntry{e, 12345}
+			}
+		}
+		return entries, err
+	}
+	defer func() { readDir = os.ReadDir }()
+
+	var last float64
+	s := NewScanner(512)
+	s.OneFileSystem = true
+	s.Progress = func(percent float64) { last = percent }
+	if _, err := s.Scan(testFilesRoot); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The root, the file and the mount point, but nothing in it
+	if s.total != 3 {
+		t.Errorf("Expecting 3 entries to be counted and not %d", s.total)
+	}
+	if last != 100 {
+		t.Errorf("Expecting the final progress to be 100 and not %v", last)
+	}
+}
@@ -0,0 +1,825 @@
+package dirtree
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_ScannerHardLinks(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "a", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	if err := os.MkdirAll(filepath.Join(testFilesRoot, "b"), 0755); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	for _, link := range []string{
+		filepath.Join(testFilesRoot, "a", "link.txt"),
+		filepath.Join(testFilesRoot, "b", "link.txt"),
+	} {
+		if err := os.Link(files[0].path, link); err != nil {
+			t.Fatalf("Failed to create test data: %v", err)
+		}
+	}
+
+	s := NewScanner(512)
+	// The file and its link in the same directory are counted once
+	a, err := s.Scan(filepath.Join(testFilesRoot, "a"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := a.calcSize(4096 + 8192); a.size != want {
+		t.Errorf("Expecting size of 'a' to be %v and not %v", want, a.size)
+	}
+	if len(a.files) != 1 {
+		t.Errorf("Expecting 1 file in 'a' and not %v", len(a.files))
+	}
+	// The scanner remembers the file for the next scan
+	b, err := s.Scan(filepath.Join(testFilesRoot, "b"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := b.calcSize(4096); b.size != want {
+		t.Errorf("Expecting size of 'b' to be %v and not %v", want, b.size)
+	}
+	if len(s.blockSizes) != 1 {
+		t.Errorf("Expecting 1 cached block size and not %v", len(s.blockSizes))
+	}
+	// Until it is reset
+	s.Reset()
+	b, _ = s.Scan(filepath.Join(testFilesRoot, "b"))
+	if want := b.calcSize(4096 + 8192); b.size != want {
+		t.Errorf("Expecting size of 'b' after reset to be %v and not %v", want, b.size)
+	}
+	if len(s.blockSizes) != 1 {
+		t.Errorf("Expecting the block sizes cache to survive reset, got %v entries", len(s.blockSizes))
+	}
+}
+
+func Test_ScannerNotExist(t *testing.T) {
+	dt, err := NewScanner(512).Scan("./ak5i8fg74")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expecting a not exist error and not %v", err)
+	}
+	if dt == nil || dt.size != 0 {
+		t.Errorf("Expecting an empty tree and not %+v", dt)
+	}
+}
+
+func Test_ScannerExclude(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	var tests = []struct {
+		name    string
+		exclude []string
+		output  []string
+	}{
+		{"Sub-directory", []string{"subdir"}, []string{
+			"8\t" + testFilesRoot + "/under_4k.txt",
+			"16\t" + testFilesRoot,
+		}},
+		{"Path", []string{filepath.Join(testFilesRoot, "*", "*")}, []string{
+			"8\t" + testFilesRoot + "/under_4k.txt",
+			"8\t" + testFilesRoot + "/subdir",
+			"24\t" + testFilesRoot,
+		}},
+		{"Everything", []string{"*"}, []string{
+			"8\t" + testFilesRoot,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScanner(512)
+			s.Exclude = tt.exclude
+			dt, err := s.Scan(testFilesRoot)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			out := dt.Print(PrintOptions{Format: "%d\t%s", CountFiles: true})
+			if len(out) != len(tt.output) {
+				t.Fatalf("Expecting %d lines and not %d: %q", len(tt.output), len(out), out)
+			}
+			for i, v := range out {
+				if v != tt.output[i] {
+					t.Errorf("Output string #%v %q is not equal to the expected one %q", i, v, tt.output[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_ScannerExcludeVCS(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, ".git", "objects", "over_4m.txt"), 5678 * 1024},
+		{filepath.Join(testFilesRoot, "subdir", "CVS", "Entries"), 5678},
+		{filepath.Join(testFilesRoot, "subdir", ".gitignore"), 10},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	s := NewScanner(512)
+	s.Exclude = VCSPatterns
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	out := dt.Print(PrintOptions{Format: "%d\t%s", CountFiles: true})
+	want := []string{
+		"8\t" + testFilesRoot + "/under_4k.txt",
+		"8\t" + testFilesRoot + "/subdir/.gitignore",
+		"16\t" + testFilesRoot + "/subdir",
+		"32\t" + testFilesRoot,
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("Expecting %q and not %q", want, out)
+	}
+}
+
+func Test_ScannerDotfiles(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, ".profile"), 1234},
+		{filepath.Join(testFilesRoot, ".hidden", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	var tests = []struct {
+		name    string
+		exclude bool
+		only    bool
+		output  []string
+	}{
+		{"Exclude", true, false, []string{
+			"8\t" + testFilesRoot + "/under_4k.txt",
+			"16\t" + testFilesRoot,
+		}},
+		{"Only", false, true, []string{
+			"8\t" + testFilesRoot + "/.profile",
+			"11360\t" + testFilesRoot + "/.hidden/over_4m.txt",
+			"11368\t" + testFilesRoot + "/.hidden",
+			"11384\t" + testFilesRoot,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScanner(512)
+			s.ExcludeDotfiles = tt.exclude
+			s.OnlyDotfiles = tt.only
+			dt, err := s.Scan(testFilesRoot)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			out := dt.Print(PrintOptions{Format: "%d\t%s", CountFiles: true})
+			if strings.Join(out, "\n") != strings.Join(tt.output, "\n") {
+				t.Errorf("Expecting output %q and not %q", tt.output, out)
+			}
+		})
+	}
+}
+
+func Test_ScannerProgress(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "exactly_4k.txt"), 4096},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "subdir", "nested", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	var got []float64
+	s := NewScanner(512)
+	s.Progress = func(percent float64) {
+		got = append(got, percent)
+	}
+	if _, err := s.Scan(testFilesRoot); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The root, 2 directories and 4 files
+	if len(got) != 7 {
+		t.Fatalf("Expecting progress to be reported 7 times and not %d: %v", len(got), got)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] < got[i-1] {
+			t.Errorf("Expecting progress to grow, got %v", got)
+		}
+	}
+	if last := got[len(got)-1]; last < 99.9 || last > 100 {
+		t.Errorf("Expecting the final progress to be 100 and not %v", last)
+	}
+}
+
+func Test_ScannerDereference(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "target", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	link := filepath.Join(testFilesRoot, "link")
+	if err := os.Symlink("target", link); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	// A link back to the root inside the target
+	if err := os.Symlink("..", filepath.Join(testFilesRoot, "target", "loop")); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	var tests = []struct {
+		name  string
+		mode  DerefMode
+		path  string
+		want  int64 // in bytes, before rounding to units
+		files int   // files on the root level
+	}{
+		// The link is counted as a file the size of its target name
+		{"Link operand, -P", DerefNone, link, int64(len("target")), 0},
+		// The target directory with a file and a link to its parent
+		{"Link operand, -H", DerefArgs, link, 4096 + 8192 + 4096, 2},
+		// Only the links themselves
+		{"Link in the tree, -H", DerefArgs, testFilesRoot, 4096 + 4096 + 4096 + 8192 + 4096, 1},
+		// The directory the link points to counted once more, the
+		// loop back to the root is not followed
+		{"Link in the tree, -L", DerefAll, testFilesRoot, 4096 + 2*(4096+8192), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScanner(512)
+			s.Dereference = tt.mode
+			dt, err := s.Scan(tt.path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if want := dt.calcSize(tt.want); dt.size != want {
+				t.Errorf("Expecting size to be %v and not %v", want, dt.size)
+			}
+			if len(dt.files) != tt.files {
+				t.Errorf("Expecting %v files and not %v", tt.files, len(dt.files))
+			}
+		})
+	}
+}
+
+func Test_ScannerRealPaths(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "target", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	if err := os.Symlink(filepath.Join("target", "over_4k.txt"), filepath.Join(testFilesRoot, "link.txt")); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	s := NewScanner(512)
+	s.Dereference = DerefAll
+	s.RealPaths = true
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dt.files) != 1 {
+		t.Fatalf("Expecting 1 file and not %v", len(dt.files))
+	}
+	want := filepath.Join(testFilesRoot, "target", "over_4k.txt")
+	if got := filepath.Clean(dt.files[0].path); got != want {
+		t.Errorf("Expecting the link to be printed as %s and not %s", want, got)
+	}
+
+	// The operand itself with -H
+	s = NewScanner(512)
+	s.Dereference = DerefArgs
+	s.RealPaths = true
+	dt, err = s.Scan(filepath.Join(testFilesRoot, "link.txt"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := filepath.Clean(dt.path); got != want {
+		t.Errorf("Expecting the operand to be printed as %s and not %s", want, got)
+	}
+}
+
+func Test_ScannerSymlinkOperand(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "target", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	link := filepath.Join(testFilesRoot, "link")
+	if err := os.Symlink("target", link); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	for _, mode := range []DerefMode{DerefArgs, DerefAll} {
+		s := NewScanner(512)
+		s.Dereference = mode
+		dt, err := s.Scan(link)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		want := []string{
+			testFilesRoot + "/link/over_4k.txt",
+			testFilesRoot + "/link",
+		}
+		if got := dt.PrintDirTree("%[2]s", true, false); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expecting the operand to be printed as typed %q and not %q", want, got)
+		}
+	}
+}
+
+func Test_ScannerDerefDepth(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "data", "a", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "data", "b", "under_4k.txt"), 3456},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	top := testFilesRoot + "/top"
+	if err := os.MkdirAll(filepath.Join(top, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	if err := os.Symlink("../data/a", filepath.Join(top, "a")); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	if err := os.Symlink("../../data/b", filepath.Join(top, "sub", "b")); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	var tests = []struct {
+		depth int
+		want  []string
+	}{
+		{1, []string{top + "/a/over_4k.txt", top + "/a", top + "/sub/b", top + "/sub", top}},
+		{2, []string{top + "/a/over_4k.txt", top + "/a", top + "/sub/b/under_4k.txt", top + "/sub/b", top + "/sub", top}},
+	}
+	for _, tt := range tests {
+		s := NewScanner(512)
+		s.Dereference = DerefAll
+		s.DerefDepth = tt.depth
+		dt, err := s.Scan(top)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got := dt.PrintDirTree("%[2]s", true, false); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Expecting the links up to %d levels down to be followed %q and not %q", tt.depth, tt.want, got)
+		}
+	}
+}
+
+func Test_ScannerStream(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "a", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "a", "b", "under_4k.txt"), 1234},
+		{filepath.Join(testFilesRoot, "c", "empty.txt"), 0},
+		{filepath.Join(testFilesRoot, "root.txt"), 10},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	opts := PrintOptions{Format: "%d\t%s", CountFiles: true}
+	var streamed []string
+	// The progress when the first line was printed
+	var percent, first float64 = 0, -1
+	s := NewScanner(512)
+	s.Progress = func(p float64) { percent = p }
+	emit := func(lines []string) {
+		if len(lines) > 0 && first < 0 {
+			first = percent
+		}
+		streamed = append(streamed, lines...)
+	}
+	s.FilesDone = func(dt *DirTree) { emit(dt.PrintFiles(opts)) }
+	s.DirDone = func(dt *DirTree) { emit(dt.PrintDir(opts)) }
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if first < 0 || first >= 100 {
+		t.Errorf("Expecting the output to start before the scan is complete, started at %v%%", first)
+	}
+	want := dt.Print(opts)
+	if !reflect.DeepEqual(streamed, want) {
+		t.Errorf("Expecting the streamed output to be\n%v\nand not\n%v", want, streamed)
+	}
+}
+
+func Test_ScannerTimeout(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "a", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "b", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "c", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	full, err := NewWithContext(context.Background(), testFilesRoot, 512)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	s := NewScanner(512)
+	// A slow filesystem
+	s.Progress = func(float64) { time.Sleep(2 * time.Millisecond) }
+	dt, err := s.ScanContext(ctx, testFilesRoot)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expecting a timeout and not %v", err)
+	}
+	if dt == nil || dt.size >= full.size {
+		t.Errorf("Expecting a partial tree smaller than %v", full.size)
+	}
+}
+
+func Test_ScannerSkipFiles(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	full, _ := NewScanner(512).Scan(testFilesRoot)
+	s := NewScanner(512)
+	s.SkipFiles = true
+	dt, _ := s.Scan(testFilesRoot)
+	if dt.size != full.size {
+		t.Errorf("Expecting size to be %v and not %v", full.size, dt.size)
+	}
+	if len(dt.files) != 0 || len(dt.subdirs[0].files) != 0 {
+		t.Errorf("Expecting the files not to be kept")
+	}
+}
+
+func Benchmark_ScannerSkipFiles(b *testing.B) {
+	var files []testFile
+	for i := 0; i < 1000; i++ {
+		files = append(files, testFile{filepath.Join(testFilesRoot, "subdir", strconv.Itoa(i)+".txt"), 10})
+	}
+	if err := createTestData(files); err != nil {
+		b.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	for _, skip := range []bool{false, true} {
+		b.Run("SkipFiles="+strconv.FormatBool(skip), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s := NewScanner(512)
+				s.SkipFiles = skip
+				s.Scan(testFilesRoot)
+			}
+		})
+	}
+}
+
+func Test_ScannerDerefArgsOnly(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "real", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "other", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	// An operand that is a link to a directory, which has a link to
+	// another directory inside
+	arg := filepath.Join(testFilesRoot, "arg")
+	if err := os.Symlink("real", arg); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	if err := os.Symlink(filepath.Join("..", "other"), filepath.Join(testFilesRoot, "real", "inner")); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	s := NewScanner(512)
+	s.Dereference = DerefArgs
+	dt, err := s.Scan(arg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The operand is followed and scanned as a directory
+	if len(dt.subdirs) != 0 || len(dt.files) != 2 {
+		t.Fatalf("Expecting the operand to be scanned as a directory with 2 files and not %+v", dt)
+	}
+	if want := dt.calcSize(4096 + 8192 + int64(len("../other"))); dt.size != want {
+		t.Errorf("Expecting size to be %v and not %v", want, dt.size)
+	}
+	// The link inside it is counted as a link, not the directory it
+	// points to
+	for _, f := range dt.files {
+		if filepath.Base(f.path) == "inner" && f.size != dt.calcSize(int64(len("../other"))) {
+			t.Errorf("Expecting the link found during the scan to be counted as a link and not %v", f.size)
+		}
+	}
+
+	// With -L both are followed
+	s = NewScanner(512)
+	s.Dereference = DerefAll
+	dt, _ = s.Scan(arg)
+	if len(dt.subdirs) != 1 || len(dt.files) != 1 {
+		t.Errorf("Expecting the link found during the scan to be followed with -L and not %+v", dt)
+	}
+}
+
+func Test_ScannerApparentSize(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "subdir", "under_4k.txt"), 3456},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	empty := filepath.Join(testFilesRoot, "empty")
+	if err := os.Mkdir(empty, 0755); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	s := NewScanner(1)
+	s.ApparentSize = true
+	// An empty directory is just its own st_size, whatever the filesystem
+	// reports, without a block added for it
+	info, err := os.Stat(empty)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	dt, _ := s.Scan(empty)
+	if dt.size != info.Size() {
+		t.Errorf("Expecting the apparent size of an empty directory to be %v and not %v", info.Size(), dt.size)
+	}
+
+	// A file is counted by its length
+	info, err = os.Stat(filepath.Join(testFilesRoot, "subdir"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	dt, _ = s.Scan(filepath.Join(testFilesRoot, "subdir"))
+	if want := info.Size() + 3456; dt.size != want {
+		t.Errorf("Expecting the apparent size to be %v and not %v", want, dt.size)
+	}
+}
+
+func Test_ScannerExactAllocated(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	var want int64
+	for _, p := range []string{testFilesRoot, filepath.Join(testFilesRoot, "subdir"), files[0].path, files[1].path} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		want += info.Sys().(*syscall.Stat_t).Blocks * 512
+	}
+
+	s := NewScanner(1)
+	s.ExactAllocated = true
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if dt.size != want {
+		t.Errorf("Expecting the size to be the sum of st_blocks*512 %v and not %v", want, dt.size)
+	}
+	if dt.size != dt.allocatedBytes {
+		t.Errorf("Expecting the size to be the allocated bytes %v and not %v", dt.allocatedBytes, dt.size)
+	}
+}
+
+func Test_ScannerDentryOverhead(t *testing.T) {
+	var files []testFile
+	for i := 0; i < 10; i++ {
+		files = append(files, testFile{filepath.Join(testFilesRoot, "many", strconv.Itoa(i)+".txt"), 10})
+	}
+	files = append(files, testFile{filepath.Join(testFilesRoot, "few", "0.txt"), 10})
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	for _, dir := range []string{"many", "few"} {
+		path := filepath.Join(testFilesRoot, dir)
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		without, _ := NewScanner(1).Scan(path)
+		s := NewScanner(1)
+		s.DentryOverhead = 100
+		with, _ := s.Scan(path)
+		if want := without.size + 100*int64(len(entries)); with.size != want {
+			t.Errorf("Expecting %s to be %d bytes with the overhead and not %d", dir, want, with.size)
+		}
+	}
+}
+
+func Test_ScannerRegularFilesOnly(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "a", "b", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	if err := os.Symlink("under_4k.txt", filepath.Join(testFilesRoot, "link.txt")); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	s := NewScanner(512)
+	s.RegularFilesOnly = true
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Neither the directories nor the link are counted
+	if want := dt.calcSize(3456) + dt.calcSize(5678); dt.size != want {
+		t.Errorf("Expecting the total to be %d and not %d", want, dt.size)
+	}
+	if len(dt.files) != 1 || dt.fileCount != 2 {
+		t.Errorf("Expecting 2 regular files and not %d", dt.fileCount)
+	}
+	if sub := dt.subdirs[0]; sub.size != dt.calcSize(5678) {
+		t.Errorf("Expecting %s to be %d and not %d", sub.path, dt.calcSize(5678), sub.size)
+	}
+}
+
+func Test_ScannerChangedSince(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "old.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "old.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "new.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	since := time.Now().Add(-time.Hour)
+	old := since.Add(-time.Hour)
+	for _, f := range files[:2] {
+		if err := os.Chtimes(f.path, old, old); err != nil {
+			t.Fatalf("Failed to create test data: %v", err)
+		}
+	}
+
+	all := New(testFilesRoot, 512)
+	s := NewScanner(512)
+	s.ChangedSince = since
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dt.files) != 0 || dt.fileCount != 1 {
+		t.Errorf("Expecting only the new file to be counted and not %d files", dt.fileCount)
+	}
+	if want := all.size - 2*dt.calcSize(3456); dt.size != want {
+		t.Errorf("Expecting the size to be %d and not %d", want, dt.size)
+	}
+
+	// A file operand is not counted either if it is older
+	if dt, _ := s.Scan(files[0].path); dt.size != 0 {
+		t.Errorf("Expecting an old file not to be counted and not %d", dt.size)
+	}
+}
+
+func Test_ScannerModifiedRange(t *testing.T) {
+	var files []testFile
+	for i := 0; i < 5; i++ {
+		files = append(files, testFile{filepath.Join(testFilesRoot, "subdir", strconv.Itoa(i)+".txt"), 3456})
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	// One file a day, from 4 days ago to today
+	now := time.Now().Truncate(time.Second)
+	day := func(i int) time.Time { return now.Add(time.Duration(i-4) * 24 * time.Hour) }
+	for i, f := range files {
+		if err := os.Chtimes(f.path, day(i), day(i)); err != nil {
+			t.Fatalf("Failed to create test data: %v", err)
+		}
+	}
+
+	var tests = []struct {
+		name     string
+		from, to time.Time
+		want     int64
+	}{
+		{"inclusive", day(1), day(3), 3},
+		{"open start", time.Time{}, day(0), 1},
+		{"open end", day(4), time.Time{}, 1},
+		{"empty", day(1).Add(time.Hour), day(2).Add(-time.Hour), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScanner(512)
+			s.ModifiedFrom, s.ModifiedTo = tt.from, tt.to
+			dt, err := s.Scan(testFilesRoot)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if dt.fileCount != tt.want {
+				t.Errorf("Expecting %d files to be counted and not %d", tt.want, dt.fileCount)
+			}
+		})
+	}
+}
+
+func Test_ScannerThrottle(t *testing.T) {
+	var files []testFile
+	for _, d := range []string{"a", "b", "c", "d", "e"} {
+		files = append(files, testFile{filepath.Join(testFilesRoot, d, "under_4k.txt"), 3456})
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	s := NewScanner(512)
+	s.Throttle = 50
+	start := time.Now()
+	s.Scan(testFilesRoot)
+	// 6 directories, one every 20ms
+	if elapsed, want := time.Since(start), 5*20*time.Millisecond; elapsed < want {
+		t.Errorf("Expecting the scan to take at least %v and not %v", want, elapsed)
+	}
+}
+
+func Test_ScannerTailPacking(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "tiny.txt"), 100},
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	s := NewScanner(1)
+	s.TailPacking = true
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Only the files smaller than a block are counted as they are, the
+	// directory is not a file
+	sizes := map[string]int64{
+		"tiny.txt":     100,
+		"under_4k.txt": 3456,
+		"over_4k.txt":  dt.calcSize(5678),
+	}
+	for _, f := range dt.files {
+		if want := sizes[filepath.Base(f.path)]; f.size != want {
+			t.Errorf("Expecting %s to take %d bytes and not %d", f.path, want, f.size)
+		}
+	}
+	if want := 100 + 3456 + dt.calcSize(5678) + dt.calcSize(4096); dt.size != want {
+		t.Errorf("Expecting the total of %d bytes and not %d", want, dt.size)
+	}
+}
@@ -0,0 +1,68 @@
+package dirtree
+
+import "sort"
+
+// SortKey tells how the entries of a tree are ordered.
+type SortKey int
+
+const (
+	// SortNone keeps the entries in the order they were read in.
+	SortNone SortKey = iota
+	// SortSize orders the entries by size, the largest first.
+	SortSize
+	// SortMtime orders the entries by modification time, the newest
+	// first. The time of a directory is the latest modification of the
+	// directory itself or anything in it, like GNU du --time shows.
+	SortMtime
+	// SortCount orders the entries by the number of files in them, the
+	// most first, which is what matters when the inodes rather than the
+	// bytes run out. A file counts as 1, so the files keep their order.
+	SortCount
+)
+
+// SortTrees returns a copy of `trees` ordered by `key`, or in the opposite
+// order if `reverse` is set. Trees that are equal by `key` keep their order.
+func SortTrees(trees []*DirTree, key SortKey, reverse bool) []*DirTree {
+	sorted := make([]*DirTree, len(trees))
+	copy(sorted, trees)
+	var less func(i, j int) bool
+	switch key {
+	case SortSize:
+		less = func(i, j int) bool { return sorted[i].size > sorted[j].size }
+	case SortMtime:
+		less = func(i, j int) bool { return sorted[i].mtime.After(sorted[j].mtime) }
+	case SortCount:
+		less = func(i, j int) bool { return sorted[i].fileCount > sorted[j].fileCount }
+	default:
+		return sorted
+	}
+	sort.SliceStable(sorted, reversed(less, reverse))
+
+	return sorted
+}
+
+// sortFiles returns a copy of `files` ordered by `key`, see SortTrees.
+func sortFiles(files []FileInfo, key SortKey, reverse bool) []FileInfo {
+	sorted := make([]FileInfo, len(files))
+	copy(sorted, files)
+	var less func(i, j int) bool
+	switch key {
+	case SortSize:
+		less = func(i, j int) bool { return sorted[i].size > sorted[j].size }
+	case SortMtime:
+		less = func(i, j int) bool { return sorted[i].mtime.After(sorted[j].mtime) }
+	default:
+		return sorted
+	}
+	sort.SliceStable(sorted, reversed(less, reverse))
+
+	return sorted
+}
+
+// reversed returns `less` with its arguments swapped if `reverse` is set.
+func reversed(less func(i, j int) bool, reverse bool) func(i, j int) bool {
+	if !reverse {
+		return less
+	}
+	return func(i, j int) bool { return less(j, i) }
+}
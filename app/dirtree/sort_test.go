@@ -0,0 +1,139 @@
+package dirtree
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_PrintSorted(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "a", "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "b", "over_4m.txt"), 5678 * 1024},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	out := dt.Print(PrintOptions{Format: "%d\t%s", CountFiles: true, Sort: SortSize})
+	want := []string{
+		"16\t" + testFilesRoot + "/over_4k.txt",
+		"8\t" + testFilesRoot + "/under_4k.txt",
+		"11360\t" + testFilesRoot + "/b/over_4m.txt",
+		"11368\t" + testFilesRoot + "/b",
+		"8\t" + testFilesRoot + "/a/under_4k.txt",
+		"16\t" + testFilesRoot + "/a",
+		"11416\t" + testFilesRoot,
+	}
+	if strings.Join(out, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, out)
+	}
+	// The tree itself is left alone
+	if filepath.Base(dt.subdirs[0].path) != "a" {
+		t.Errorf("Expecting the sub-directories of the tree to keep their order")
+	}
+}
+
+func Test_PrintSortedMtime(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "old.txt"), 3456},
+		{filepath.Join(testFilesRoot, "new.txt"), 3456},
+		{filepath.Join(testFilesRoot, "a", "older.txt"), 3456},
+		{filepath.Join(testFilesRoot, "b", "newest.txt"), 3456},
+		{filepath.Join(testFilesRoot, "b", "oldest.txt"), 3456},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	// The directories are older than anything in them, b is as new as its
+	// newest file
+	base := time.Now().Add(-time.Hour)
+	times := map[string]time.Duration{
+		"b/oldest.txt": 1, "a": 2, "b": 2, ".": 2, "a/older.txt": 3,
+		"old.txt": 4, "new.txt": 5, "b/newest.txt": 6,
+	}
+	for name, m := range times {
+		mtime := base.Add(m * time.Minute)
+		if err := os.Chtimes(filepath.Join(testFilesRoot, name), mtime, mtime); err != nil {
+			t.Fatalf("Failed to create test data: %v", err)
+		}
+	}
+
+	dt := New(testFilesRoot, 512)
+	got := dt.Print(PrintOptions{Format: "%[2]s", CountFiles: true, Sort: SortMtime})
+	want := []string{
+		testFilesRoot + "/new.txt",
+		testFilesRoot + "/old.txt",
+		testFilesRoot + "/b/newest.txt",
+		testFilesRoot + "/b/oldest.txt",
+		testFilesRoot + "/b",
+		testFilesRoot + "/a/older.txt",
+		testFilesRoot + "/a",
+		testFilesRoot,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting the newest first %q and not %q", want, got)
+	}
+	got = dt.Print(PrintOptions{Format: "%[2]s", CountFiles: true, Sort: SortMtime, Reverse: true})
+	want = []string{
+		testFilesRoot + "/old.txt",
+		testFilesRoot + "/new.txt",
+		testFilesRoot + "/a/older.txt",
+		testFilesRoot + "/a",
+		testFilesRoot + "/b/oldest.txt",
+		testFilesRoot + "/b/newest.txt",
+		testFilesRoot + "/b",
+		testFilesRoot,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting the oldest first %q and not %q", want, got)
+	}
+}
+
+func Test_PrintSortedCount(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "large", "over_4m.txt"), 5678 * 1024},
+		{filepath.Join(testFilesRoot, "many", "a.txt"), 100},
+		{filepath.Join(testFilesRoot, "many", "b.txt"), 100},
+		{filepath.Join(testFilesRoot, "many", "c.txt"), 100},
+		{filepath.Join(testFilesRoot, "some", "a.txt"), 100},
+		{filepath.Join(testFilesRoot, "some", "b.txt"), 100},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	dt := New(testFilesRoot, 512)
+	got := dt.Print(PrintOptions{Format: "%d\t%s", Counts: true, Sort: SortCount})
+	want := []string{
+		"32\t3\t" + testFilesRoot + "/many",
+		"24\t2\t" + testFilesRoot + "/some",
+		"11368\t1\t" + testFilesRoot + "/large",
+		"11432\t6\t" + testFilesRoot,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting the most files first %q and not %q", want, got)
+	}
+}
+
+func Test_SortTrees(t *testing.T) {
+	trees := []*DirTree{{path: "a", size: 8}, {path: "b", size: 16}, {path: "c", size: 8}}
+	var got []string
+	for _, dt := range SortTrees(trees, SortSize, false) {
+		got = append(got, dt.path)
+	}
+	if want := "b a c"; strings.Join(got, " ") != want {
+		t.Errorf("Expecting the trees to be sorted as %q and not %q", want, strings.Join(got, " "))
+	}
+	if trees[0].path != "a" {
+		t.Errorf("Expecting the original trees to keep their order")
+	}
+}
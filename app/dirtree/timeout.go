@@ -0,0 +1,60 @@
+package dirtree
+
+import (
+	"os"
+	"time"
+)
+
+// callTimeout returns what `call` returns, unless it takes longer than
+// OpTimeout. Then a *os.PathError for `op` on `path` wrapping
+// os.ErrDeadlineExceeded is returned instead, so that the entry is
+// reported and skipped like any other that cannot be read. A system call
+// cannot be interrupted though, the goroutine running the call is left
+// behind until it returns, if ever.
+func (s *Scanner) callTimeout(op, path string, call func() (interface{}, error)) (interface{}, error) {
+	if s.OpTimeout <= 0 {
+		return call()
+	}
+	type result struct {
+		v   interface{}
+		err error
+	}
+	// Buffered so that a call that returns too late doesn't block forever
+	done := make(chan result, 1)
+	go func() {
+		v, err := call()
+		done <- result{v, err}
+	}()
+	timer := time.NewTimer(s.OpTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-timer.C:
+		return nil, &os.PathError{Op: op, Path: path, Err: os.ErrDeadlineExceeded}
+	}
+}
+
+// readDirTimeout is readDir with OpTimeout.
+func (s *Scanner) readDirTimeout(path string) ([]os.DirEntry, error) {
+	// Left behind, the call mustn't race with the tests restoring readDir
+	read := readDir
+	v, err := s.callTimeout("open", path, func() (interface{}, error) { return read(path) })
+	files, _ := v.([]os.DirEntry)
+	return files, err
+}
+
+// statTimeout is `stat`, e.g. lstat, with OpTimeout.
+func (s *Scanner) statTimeout(stat func(string) (os.FileInfo, error), path string) (os.FileInfo, error) {
+	v, err := s.callTimeout("lstat", path, func() (interface{}, error) { return stat(path) })
+	info, _ := v.(os.FileInfo)
+	return info, err
+}
+
+// infoTimeout is the Info method of the directory entry `e` of the file at
+// `path` with OpTimeout.
+func (s *Scanner) infoTimeout(e os.DirEntry, path string) (os.FileInfo, error) {
+	v, err := s.callTimeout("lstat", path, func() (interface{}, error) { return e.Info() })
+	info, _ := v.(os.FileInfo)
+	return info, err
+}
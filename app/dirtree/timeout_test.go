@@ -0,0 +1,117 @@
+package dirtree
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_ScannerOpTimeout(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "hung", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "ok", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	errLog.SetOutput(ioutil.Discard)
+	defer errLog.SetOutput(os.Stderr)
+	// Reading "hung" blocks until the end of the test, like a network
+	// filesystem that doesn't answer
+	hung := filepath.Join(testFilesRoot, "hung")
+	release := make(chan struct{})
+	defer close(release)
+	readDir = func(name string) ([]os.DirEntry, error) {
+		if name == hung {
+			<-release
+		}
+		return os.ReadDir(name)
+	}
+	defer func() { readDir = os.ReadDir }()
+
+	s := NewScanner(512)
+	s.OpTimeout = 50 * time.Millisecond
+	start := time.Now()
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expecting the scan to go on after the timeout and not to take %v", elapsed)
+	}
+	// The hung directory itself is counted, but nothing in it
+	if want := dt.calcSize(4096)*3 + dt.calcSize(3456) + dt.calcSize(5678); dt.size != want {
+		t.Errorf("Expecting size %d without the hung directory and not %d", want, dt.size)
+	}
+	errs := s.Errors()
+	if len(errs) != 1 || errs[0].Path != hung || !errors.Is(errs[0], os.ErrDeadlineExceeded) {
+		t.Errorf("Expecting a timeout for %q and not %v", hung, errs)
+	}
+}
+
+func Test_ScannerOpTimeoutProgress(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "hung", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	errLog.SetOutput(ioutil.Discard)
+	defer errLog.SetOutput(os.Stderr)
+	hung := filepath.Join(testFilesRoot, "hung")
+	release := make(chan struct{})
+	defer close(release)
+	readDir = func(name string) ([]os.DirEntry, error) {
+		if name == hung {
+			<-release
+		}
+		return os.ReadDir(name)
+	}
+	defer func() { readDir = os.ReadDir }()
+
+	// The count before the scan gives up on the hung directory as well
+	s := NewScanner(512)
+	s.OpTimeout = 50 * time.Millisecond
+	s.Progress = func(float64) {}
+	done := make(chan struct{})
+	go func() {
+		s.Scan(testFilesRoot)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expecting the scan with progress to go on after the timeout")
+	}
+	// The root, the file and the hung directory
+	if s.total != 3 {
+		t.Errorf("Expecting 3 entries to be counted and not %d", s.total)
+	}
+}
+
+func Test_CallTimeout(t *testing.T) {
+	s := NewScanner(512)
+	// No timeout by default
+	v, err := s.callTimeout("open", "dir", func() (interface{}, error) { return 1, nil })
+	if v != 1 || err != nil {
+		t.Errorf("Expecting the result of the call and not %v, %v", v, err)
+	}
+	s.OpTimeout = time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+	_, err = s.callTimeout("open", "dir", func() (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+	var pe *os.PathError
+	if !errors.As(err, &pe) || pe.Op != "open" || pe.Path != "dir" || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expecting a timeout error and not %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package dirtree
+
+// VerifyThreshold is how much the total of a tree may change between the
+// scans, relative to the first one, before Verify considers it inconsistent.
+const VerifyThreshold = 0.01
+
+// Counted is a copy of the files with multiple hard links and of the shared
+// extents that a Scanner has counted so far, see Scanner.Counted and Verify.
+// The zero value is a Scanner that has not counted anything yet.
+type Counted struct {
+	inodes  map[fileID]bool
+	extents map[extentID]uint64
+}
+
+// Counted returns a copy of the files and the extents that `s` has counted so
+// far, to verify the next scan with.
+func (s *Scanner) Counted() Counted {
+	return Counted{inodes: s.inodes, extents: s.extents}.copy()
+}
+
+// copy returns a deep copy of `c`, the maps are changed while scanning.
+func (c Counted) copy() Counted {
+	cp := Counted{inodes: make(map[fileID]bool, len(c.inodes)), extents: make(map[extentID]uint64, len(c.extents))}
+	for id := range c.inodes {
+		cp.inodes[id] = true
+	}
+	for id, l := range c.extents {
+		cp.extents[id] = l
+	}
+
+	return cp
+}
+
+// Verify scans the tree `dt` scanned by `s` once more with the same settings
+// and returns its new total in units, and whether it differs from the total
+// in `dt` by more than VerifyThreshold. On an active filesystem the
+// different parts of a tree are read at different times, so a changed total
+// means that the sizes in `dt` are approximate.
+//
+// The second scan starts from `from`, what `s` had counted before it scanned
+// `dt`, so that the files with multiple hard links and the shared extents
+// counted by the previous scans are skipped again. Neither `s` nor `from` are
+// changed. Its errors are not printed out or added to Errors and the
+// callbacks are not called.
+func (s *Scanner) Verify(dt *DirTree, from Counted) (int64, bool) {
+	v := *s
+	v.Progress, v.FilesDone, v.DirDone = nil, nil, nil
+	v.SkipFiles = true
+	start := from.copy()
+	v.inodes, v.extents = start.inodes, start.extents
+	v.parents = make(map[fileID]bool)
+	v.errs = nil
+	v.quiet = true
+	again, _ := v.Scan(dt.path)
+
+	diff := again.size - dt.size
+	if diff < 0 {
+		diff = -diff
+	}
+	return again.size, float64(diff) > VerifyThreshold*float64(dt.size)
+}
@@ -0,0 +1,77 @@
+package dirtree
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ScannerVerify(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "subdir", "over_4k.txt"), 5678},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+
+	s := NewScanner(512)
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if size, changed := s.Verify(dt, Counted{}); changed || size != dt.size {
+		t.Errorf("Expecting the unchanged total %d to be verified and not %d", dt.size, size)
+	}
+
+	// A file grows between the passes
+	if err := ioutil.WriteFile(files[0].path, make([]byte, 1<<20), 0644); err != nil {
+		t.Fatalf("Failed to change test data: %v", err)
+	}
+	size, changed := s.Verify(dt, Counted{})
+	if !changed {
+		t.Errorf("Expecting the total to have changed from %d, not %d", dt.size, size)
+	}
+	if size <= dt.size {
+		t.Errorf("Expecting the new total %d to be bigger than %d", size, dt.size)
+	}
+	if s.Failed() {
+		t.Errorf("Expecting no errors from the verification, not %v", s.Errors())
+	}
+}
+
+func Test_ScannerVerifyHardLinks(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "a", "over_4k.txt"), 5678},
+		{filepath.Join(testFilesRoot, "e", "under_4k.txt"), 3456},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	if err := os.Link(files[0].path, filepath.Join(testFilesRoot, "e", "link.txt")); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	// The hard link under e was already counted under a
+	s := NewScanner(512)
+	s.Scan(filepath.Join(testFilesRoot, "a"))
+	counted := s.Counted()
+	dt, err := s.Scan(filepath.Join(testFilesRoot, "e"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if size, changed := s.Verify(dt, counted); changed || size != dt.size {
+		t.Errorf("Expecting the unchanged total %d to be verified and not %d", dt.size, size)
+	}
+	// Scanning afresh counts it once more
+	if size, changed := s.Verify(dt, Counted{}); !changed || size <= dt.size {
+		t.Errorf("Expecting the total %d to change without the counted files and not %d", dt.size, size)
+	}
+	// Verify doesn't change what is counted
+	if size, _ := s.Verify(dt, counted); size != dt.size {
+		t.Errorf("Expecting the total %d to be verified again and not %d", dt.size, size)
+	}
+}
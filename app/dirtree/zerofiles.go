@@ -0,0 +1,33 @@
+package dirtree
+
+import (
+	"fmt"
+	"os"
+)
+
+// addZeroFile remembers the file at `path` described by `info` if it is a
+// regular file with no data in it, see Scanner.ZeroByteFiles.
+func (dt *DirTree) addZeroFile(path string, info os.FileInfo) {
+	if info.Mode().IsRegular() && info.Size() == 0 {
+		dt.zeroFiles = append(dt.zeroFiles, path)
+	}
+}
+
+// PrintZeroByteFiles returns a line with the number of the regular files
+// with no data in them found in `dt`, for example
+// "zero-byte files: 3\t./dir". If `list` is set the line is preceded by the
+// paths of the files, one per line, in the same order as Print prints them.
+//
+// Lots of empty files are often a sign of a bug in the program that wrote
+// them. The files are only known if the tree was scanned with
+// Scanner.ZeroByteFiles.
+func (dt *DirTree) PrintZeroByteFiles(opts PrintOptions, list bool) []string {
+	var out []string
+	if list {
+		for _, path := range dt.zeroFiles {
+			out = append(out, opts.path(entry{path: path}))
+		}
+	}
+
+	return append(out, fmt.Sprintf("zero-byte files: %d\t%s", len(dt.zeroFiles), opts.path(dt.dirEntry())))
+}
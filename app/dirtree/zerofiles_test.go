@@ -0,0 +1,55 @@
+package dirtree
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_PrintZeroByteFiles(t *testing.T) {
+	files := []testFile{
+		{filepath.Join(testFilesRoot, "under_4k.txt"), 3456},
+		{filepath.Join(testFilesRoot, "empty.txt"), 0},
+		{filepath.Join(testFilesRoot, "subdir", "empty1.txt"), 0},
+		{filepath.Join(testFilesRoot, "subdir", "empty2.txt"), 0},
+		{filepath.Join(testFilesRoot, "subdir", "deeper", "empty3.txt"), 0},
+	}
+	if err := createTestData(files); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer resetTestData()
+	// Neither an empty directory nor a symbolic link is an empty file
+	if err := os.Mkdir(filepath.Join(testFilesRoot, "nothing"), 0755); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	if err := os.Symlink("empty.txt", filepath.Join(testFilesRoot, "link.txt")); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	s := NewScanner(512)
+	s.ZeroByteFiles = true
+	dt, err := s.Scan(testFilesRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"zero-byte files: 4\t" + testFilesRoot}
+	if got := dt.PrintZeroByteFiles(PrintOptions{}, false); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting %q and not %q", want, got)
+	}
+	want = append([]string{
+		testFilesRoot + "/empty.txt",
+		testFilesRoot + "/subdir/empty1.txt",
+		testFilesRoot + "/subdir/empty2.txt",
+		testFilesRoot + "/subdir/deeper/empty3.txt",
+	}, want...)
+	if got := dt.PrintZeroByteFiles(PrintOptions{}, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting %q and not %q", want, got)
+	}
+	// Only the root keeps them
+	for _, d := range dt.subdirs {
+		if d.zeroFiles != nil {
+			t.Errorf("Expecting no zero-byte files in %s and not %q", d.path, d.zeroFiles)
+		}
+	}
+}
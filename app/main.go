@@ -24,6 +24,9 @@
 // and written just once, or is counted and written for each
 // occurrence. It is implementation-defined whether a file that
 // occurs under one file operand is counted for other file operands.
+// (This implementation counts a file once for each operand it occurs
+// under, unless --dedup-operands is given, in which case it is counted
+// only for the first one.)
 // The directory entry that is selected in the report is
 // unspecified. By default, file sizes shall be written in 512-byte
 // units, rounded up to the next 512-byte unit.
@@ -31,10 +34,20 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/iliafrenkel/go-du/app/dirtree"
 )
@@ -42,19 +55,209 @@ import (
 // Format for printing out dir/file entry
 const outFormat = "%d\t%s"
 
+// Format for printing out dir/file entry as POSIX specifies it, with
+// --posix-1k
+const posixFormat = "%d %s"
+
+// Format for printing out just the path of an entry, with --paths-only
+const pathFormat = "%[2]s"
+
 // Command-line flags
 type options struct {
-	BlockSize       bool `short:"k" default:"false" description:"Write the files sizes in units of 1024 bytes, rather than the default 512-byte units"`
-	CountFiles      bool `short:"a" long:"all" default:"false" description:"write counts for all files, not just directories"`
-	DereferenceAll  bool `short:"L" long:"dereference" default:"false" description:"dereference all symbolic links"`
-	DereferenceArgs bool `short:"H" long:"dereference-args" default:"false" description:"dereference only symlinks that are listed on the command line"`
-	OneFileSystem   bool `short:"x" long:"one-file-system" default:"false" description:"skip directories on different file systems"`
-	Summarise       bool `short:"s" long:"summarise" default:"false" description:"display only a total for each argument"`
-	Version         bool `short:"v" long:"version" default:"false" description:"show version info and exit"`
+	ApparentSize        bool          `long:"apparent-size" default:"false" description:"print apparent sizes rather than disk usage"`
+	BlockSize           bool          `short:"k" default:"false" description:"Write the files sizes in units of 1024 bytes, rather than the default 512-byte units"`
+	BlockSizeM          bool          `short:"m" default:"false" description:"write the sizes in units of 1M"`
+	BlockSizeG          bool          `short:"g" default:"false" description:"write the sizes in units of 1G"`
+	Breakdown           bool          `long:"breakdown" default:"false" description:"print how much of each total is files and how much directories"`
+	ByGroup             bool          `long:"by-group" default:"false" description:"print the total size of the files of each group"`
+	ByOwner             bool          `long:"by-owner" default:"false" description:"print the total size of the files of each owner"`
+	BytesAndHuman       bool          `long:"human-readable-si-bytes" default:"false" description:"print the sizes both in bytes and in human readable format"`
+	ChangedSinceFile    string        `long:"changed-since-file" description:"count only the files modified after FILE was"`
+	Color               string        `long:"color" default:"never" description:"colour the sizes of big entries; WHEN is 'auto', 'always' or 'never'"`
+	Compression         bool          `long:"compression-ratio" default:"false" description:"print the allocated and apparent sizes of the directories and their ratio"`
+	CountFiles          bool          `short:"a" long:"all" default:"false" description:"write counts for all files, not just directories"`
+	Canonicalize        bool          `long:"canonicalize" default:"false" description:"remove the redundant . and .. and separators from the arguments"`
+	CollapseOperands    bool          `long:"collapse-operands" default:"false" description:"skip the arguments inside the directories given as other arguments"`
+	Combine             bool          `long:"combine" default:"false" description:"treat all the arguments as one tree and print their combined total"`
+	CrossFileSystems    bool          `long:"cross-file-systems" default:"false" description:"scan the file systems mounted under / as well"`
+	CurrentSummary      bool          `long:"current-summary" default:"false" description:"with no arguments, print only the total of the current directory"`
+	DebugBlocks         bool          `long:"debug-blocks" default:"false" description:"print the blocks allocated to each file and directory (st_blocks) after its size"`
+	DentryOverhead      int64         `long:"dentry-overhead" description:"add N bytes for each entry of a directory to its size"`
+	DedupExtents        bool          `long:"dedup-extents" default:"false" description:"count the extents shared by several files only once (experimental)"`
+	DedupOperands       bool          `long:"dedup-operands" default:"false" description:"count files with multiple hard links only once across all operands"`
+	DepthSummary        bool          `long:"depth-summary" default:"false" description:"print the number and the size of the directories at each depth"`
+	DerefDepth          int           `long:"deref-depth" description:"dereference only the symbolic links up to N levels below the arguments"`
+	DereferenceAll      bool          `short:"L" long:"dereference" default:"false" description:"dereference all symbolic links"`
+	DereferenceArgs     bool          `short:"H" long:"dereference-args" default:"false" description:"dereference only symlinks that are listed on the command line"`
+	Diff                string        `long:"diff" description:"print how the sizes of the directories have changed since the scan saved in FILE"`
+	ExactAllocated      bool          `long:"exact-allocated" default:"false" description:"print the exact number of bytes allocated (st_blocks*512)"`
+	ExcludeVCS          bool          `long:"exclude-vcs" default:"false" description:"skip the version control metadata directories (.git, .svn, .hg, .bzr and CVS)"`
+	ExcludeDotfiles     bool          `long:"exclude-dotfiles" default:"false" description:"skip hidden files and directories"`
+	Exclude             patterns      `long:"exclude" description:"exclude files that match PATTERN"`
+	FailFast            bool          `long:"fail-fast" default:"false" description:"stop at the first error"`
+	Flat                bool          `long:"flat" default:"false" description:"list only the files with their sizes, without any directory totals"`
+	FromStdin           bool          `long:"from-stdin" default:"false" description:"read the files to scan from stdin, one per line"`
+	Format              string        `long:"format" default:"text" description:"output format; FORMAT is 'text', 'json', 'ndjson' or 'csv'"`
+	GitIgnore           bool          `long:"gitignore" default:"false" description:"skip files ignored by the .gitignore files"`
+	Histogram           bool          `long:"histogram" default:"false" description:"print how many files of different sizes there are and how much space they take"`
+	HumanReadable       bool          `short:"h" long:"human-readable" default:"false" description:"print sizes in human readable format (e.g., 1.5K, 234M, 2.0G)"`
+	HumanStyle          string        `long:"human-style" default:"short" description:"how the suffixes of the human readable sizes are written; STYLE is 'short', 'iec' or 'space'"`
+	Interactive         bool          `long:"interactive" default:"false" description:"browse the results interactively"`
+	Jobs                int           `long:"jobs" description:"scan up to N arguments at the same time"`
+	LeafDepth           int           `long:"leaf-depth" default:"-1" description:"print the total for a directory only if it is N levels above the deepest directory under it"`
+	MaxDepth            maxDepth      `short:"d" long:"max-depth" default:"-1" description:"print the total for a directory only if it is N or fewer levels below the argument"`
+	MaxResults          int           `long:"max-results" description:"print at most N entries, the scan and the totals are not affected"`
+	Merge               bool          `long:"merge" default:"false" description:"print each path once, with the largest size found for it"`
+	MtimeBetween        string        `long:"mtime-between" description:"count only the files modified between START and END (RFC3339), inclusive"`
+	MinSize             string        `long:"min-size" description:"do not print entries smaller than SIZE, in the same units as the output"`
+	NoSmallFileRounding bool          `long:"no-block-rounding-for-small-files" default:"false" description:"count the apparent sizes of the files smaller than a block"`
+	NormalizeUnicode    bool          `long:"normalize-unicode" default:"false" description:"write the paths in the Unicode normalization form C (NFC)"`
+	Null                bool          `short:"0" long:"null" default:"false" description:"end each output line with NUL, not newline"`
+	NoDereference       bool          `short:"P" long:"no-dereference" default:"false" description:"don't follow any symbolic links (this is the default)"`
+	OnlyDotfiles        bool          `long:"only-dotfiles" default:"false" description:"count only hidden files and directories"`
+	OneFilePerOperand   bool          `long:"one-file-per-operand" default:"false" description:"count files with multiple hard links once for each operand (the default)"`
+	OneFileSystem       bool          `short:"x" long:"one-file-system" default:"false" description:"skip directories on different file systems"`
+	OpTimeout           time.Duration `long:"op-timeout" description:"skip the directories and files that take longer than DURATION to read"`
+	PadSizes            int           `long:"pad-sizes" description:"pad the sizes with spaces to at least N characters"`
+	PathsOnly           bool          `long:"paths-only" default:"false" description:"print only the paths of the entries, without the sizes"`
+	PortableOutput      bool          `long:"portable-output" default:"false" description:"separate the directories in the paths with '/' on any system"`
+	Posix1K             bool          `long:"posix-1k" default:"false" description:"write the sizes in 1024-byte units exactly in the format POSIX specifies for du -k"`
+	Prefix              string        `long:"prefix" description:"prefix the printed paths with PATH"`
+	Pretty              bool          `long:"pretty" default:"false" description:"indent the JSON output"`
+	PrintRealPath       bool          `long:"print-real-path" default:"false" description:"print the real paths of the dereferenced symbolic links"`
+	Progress            bool          `long:"progress" default:"false" description:"show the progress of the scan on stderr"`
+	RegularFilesOnly    bool          `long:"count-only-regular-files" default:"false" description:"count only the regular files, not the directories themselves"`
+	ReportInaccessible  reportTarget  `long:"report-inaccessible" description:"list the paths that could not be read after the scan, to stderr or to FILE"`
+	ResolveMounts       bool          `long:"resolve-mountpoints" default:"false" description:"mark the directories that are mount points with [mount]"`
+	Retry               int           `long:"retry" description:"retry reading a file or a directory up to N times after a transient error"`
+	Reverse             bool          `short:"r" long:"reverse" default:"false" description:"reverse the order of --sort"`
+	ScanTimeout         time.Duration `long:"scan-timeout" description:"stop scanning after DURATION (e.g., 30s, 5m) and print what is scanned so far"`
+	SkipEmptyDirs       bool          `long:"skip-empty-dirs" default:"false" description:"do not print the directories without regular files in them"`
+	ShowCounts          bool          `long:"show-counts" default:"false" description:"print the number of files in each directory after its size"`
+	Sort                string        `long:"sort" default:"none" description:"order the entries; WORD is 'none', 'size', 'mtime' or 'count'"`
+	Summarise           bool          `short:"s" long:"summarise" default:"false" description:"display only a total for each argument"`
+	SummarizeChildren   bool          `long:"summarize-with-children-count" default:"false" description:"with -s, print the number of files and directories directly in each argument"`
+	SummarizePercent    bool          `long:"summarize-percent" default:"false" description:"print the size of each entry as a percentage of its directory"`
+	SummaryOut          string        `long:"summary-out" description:"write the total of each argument in bytes to FILE as well"`
+	Throttle            int           `long:"throttle" description:"read at most about N directories per second"`
+	TimeThreshold       time.Duration `long:"time-threshold" description:"mark the directories not modified for DURATION with [stale]"`
+	Truncate            int           `long:"truncate" description:"shorten the paths longer than N characters in the output"`
+	TotalsOnly          bool          `long:"total-for-root-only" default:"false" description:"print only the total for each argument, even with -a"`
+	TotalAtTop          bool          `long:"total-at-top" default:"false" description:"print the total for each argument before its content"`
+	UseFrsize           bool          `long:"use-frsize" default:"false" description:"use the fundamental block size of the filesystems to calculate the disk usage"`
+	VerboseErrors       bool          `long:"verbose-errors" default:"false" description:"print errors with the path that caused them"`
+	UnitSize            string        `short:"B" long:"block-size" description:"write the sizes in units of SIZE bytes (e.g., 1024, 4K, 1M) or 'human' for -h"`
+	Verify              bool          `long:"verify" default:"false" description:"scan each argument twice and warn if its total has changed in between"`
+	Version             bool          `short:"v" long:"version" default:"false" description:"show version info and exit"`
+	ZeroByteReport      zeroReport    `long:"zero-byte-report" description:"print the number of empty regular files, with =list their paths as well"`
+	ZeroPadSizes        int           `long:"zero-pad-sizes" description:"pad the sizes with zeros to at least N characters"`
 }
 
 var opts options
 
+// patterns is a flag that can be given multiple times, each time adding a
+// pattern to the list.
+type patterns []string
+
+// String implements flag.Value.
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+// Set implements flag.Value.
+func (p *patterns) Set(v string) error {
+	*p = append(*p, v)
+	return nil
+}
+
+// maxDepth is a flag for the depth limit. Any negative number, "inf",
+// "infinity" and "unlimited" mean no limit, which is stored as -1.
+type maxDepth int
+
+// String implements flag.Value.
+func (d *maxDepth) String() string {
+	if *d < 0 {
+		return "inf"
+	}
+	return strconv.Itoa(int(*d))
+}
+
+// Set implements flag.Value.
+func (d *maxDepth) Set(v string) error {
+	switch strings.ToLower(v) {
+	case "inf", "infinity", "unlimited":
+		*d = -1
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid depth '%s'", v)
+	}
+	if n < 0 {
+		n = -1
+	}
+	*d = maxDepth(n)
+	return nil
+}
+
+// reportTarget is a flag that can be given with or without a value: on its
+// own it is "-" for stderr, otherwise it is the name of a file. It is empty
+// if the flag is not given.
+type reportTarget string
+
+// String implements flag.Value.
+func (r *reportTarget) String() string {
+	return string(*r)
+}
+
+// Set implements flag.Value.
+func (r *reportTarget) Set(v string) error {
+	switch v {
+	case "true":
+		*r = "-"
+	case "false":
+		*r = ""
+	default:
+		*r = reportTarget(v)
+	}
+	return nil
+}
+
+// IsBoolFlag allows the flag without a value, see flag.Value.
+func (r *reportTarget) IsBoolFlag() bool {
+	return true
+}
+
+// zeroReport is a flag that can be given with or without a value: on its
+// own it is "count", otherwise it is "count" or "list". It is empty if the
+// flag is not given.
+type zeroReport string
+
+// String implements flag.Value.
+func (z *zeroReport) String() string {
+	return string(*z)
+}
+
+// Set implements flag.Value.
+func (z *zeroReport) Set(v string) error {
+	switch v {
+	case "true", "count":
+		*z = "count"
+	case "list":
+		*z = "list"
+	case "false":
+		*z = ""
+	default:
+		return fmt.Errorf("invalid argument '%s', valid arguments are 'count' and 'list'", v)
+	}
+	return nil
+}
+
+// IsBoolFlag allows the flag without a value, see flag.Value.
+func (z *zeroReport) IsBoolFlag() bool {
+	return true
+}
+
 // Version information, comes from the build flags (see Makefile)
 var (
 	revision = "unknown"
@@ -76,10 +279,391 @@ func conflictingFlags() bool {
 		errLog.Println("Cannot both summarise and show all entries.")
 		return true
 	}
+	if opts.Flat && (opts.Summarise || opts.MaxDepth >= 0 || opts.TotalsOnly || opts.TotalAtTop || opts.Format != "text") {
+		errLog.Println("Cannot combine --flat with -s, --max-depth, --total-for-root-only, --total-at-top or other formats than text.")
+		return true
+	}
+	if opts.Summarise && opts.MaxDepth > 0 {
+		errLog.Printf("Summarising conflicts with --max-depth=%d.\n", opts.MaxDepth)
+		return true
+	}
+	if count(opts.DereferenceAll, opts.DereferenceArgs, opts.NoDereference) > 1 {
+		errLog.Println("Only one of -H, -L and -P can be given.")
+		return true
+	}
+	if opts.DerefDepth > 0 && (opts.DereferenceArgs || opts.NoDereference) {
+		errLog.Println("Cannot combine --deref-depth with -H or -P.")
+		return true
+	}
+	if opts.FromStdin && opts.Interactive {
+		errLog.Println("Cannot both read the files from stdin and browse interactively.")
+		return true
+	}
+	if opts.ExcludeDotfiles && opts.OnlyDotfiles {
+		errLog.Println("Cannot both exclude and count only the hidden files.")
+		return true
+	}
+	if opts.ByOwner && opts.ByGroup {
+		errLog.Println("Only one of --by-owner and --by-group can be given.")
+		return true
+	}
+	if (opts.ByOwner || opts.ByGroup) && opts.Format != "text" {
+		errLog.Println("Only the output in --format=text can be grouped by owner or group.")
+		return true
+	}
+	if opts.TimeThreshold > 0 && opts.Format != "text" {
+		errLog.Println("Only the output in --format=text can mark the stale directories.")
+		return true
+	}
+	if opts.DepthSummary && opts.Format != "text" {
+		errLog.Println("Only the output in --format=text can be summarised by depth.")
+		return true
+	}
+	if opts.PathsOnly && opts.Format != "text" {
+		errLog.Println("Only the output in --format=text can have the paths only.")
+		return true
+	}
+	if opts.PadSizes > 0 && opts.ZeroPadSizes > 0 {
+		errLog.Println("Only one of --pad-sizes and --zero-pad-sizes can be given.")
+		return true
+	}
+	if opts.SummarizeChildren && (!(opts.Summarise || opts.MaxDepth == 0) || opts.Format != "text") {
+		errLog.Println("Only the summaries (-s) in --format=text can have the number of children.")
+		return true
+	}
+	if opts.Merge && (opts.HumanReadable || opts.BytesAndHuman || opts.ShowCounts || opts.DebugBlocks || opts.SummarizeChildren || opts.SummarizePercent || opts.Posix1K || opts.Format != "text") {
+		errLog.Println("Only the plain sizes in --format=text can be merged.")
+		return true
+	}
+	if opts.MaxResults > 0 && opts.Format == "json" {
+		errLog.Println("The output in --format=json cannot be limited to --max-results.")
+		return true
+	}
+	if opts.ZeroByteReport != "" && opts.Format != "text" {
+		errLog.Println("Only the output in --format=text can have the zero-byte files report.")
+		return true
+	}
+	if opts.Reverse && (opts.Sort == "" || opts.Sort == "none") {
+		errLog.Println("Only the entries ordered with --sort can be reversed.")
+		return true
+	}
+	if opts.Format == "csv" && (opts.HumanReadable || opts.BytesAndHuman || opts.ShowCounts || opts.DebugBlocks || opts.PadSizes > 0 || opts.ZeroPadSizes > 0) {
+		errLog.Println("Cannot combine --format=csv with human readable, padded sizes or counts.")
+		return true
+	}
+	if opts.OneFilePerOperand && (opts.DedupOperands || opts.Combine) {
+		errLog.Println("Cannot combine --one-file-per-operand with --dedup-operands or --combine.")
+		return true
+	}
+	if opts.OneFileSystem && opts.CrossFileSystems {
+		errLog.Println("Cannot combine -x with --cross-file-systems.")
+		return true
+	}
+	if opts.Pretty && opts.Format != "json" {
+		errLog.Println("Only the output in --format=json can be pretty printed.")
+		return true
+	}
+	if count(opts.BlockSize, opts.BlockSizeM, opts.BlockSizeG) > 1 {
+		errLog.Println("Only one of -k, -m and -g can be given.")
+		return true
+	}
+	if opts.NoSmallFileRounding && (opts.ApparentSize || opts.ExactAllocated) {
+		errLog.Println("Cannot combine --no-block-rounding-for-small-files with apparent or exact allocated sizes.")
+		return true
+	}
+	if opts.DedupExtents && opts.ApparentSize {
+		errLog.Println("Cannot combine --dedup-extents with apparent sizes.")
+		return true
+	}
+	if opts.Posix1K && (opts.HumanReadable || opts.BytesAndHuman || opts.UnitSize != "" || opts.BlockSizeM || opts.BlockSizeG ||
+		opts.ExactAllocated || opts.PathsOnly || opts.ShowCounts || opts.DebugBlocks || opts.SummarizeChildren || opts.PadSizes > 0 || opts.ZeroPadSizes > 0 || opts.Format != "text") {
+		errLog.Println("Cannot combine --posix-1k with other units or output formats.")
+		return true
+	}
+	if opts.SummarizePercent && (opts.HumanReadable || opts.BytesAndHuman || opts.PadSizes > 0 || opts.ZeroPadSizes > 0 || opts.Format != "text") {
+		errLog.Println("Cannot combine --summarize-percent with human readable, padded or other formats than text.")
+		return true
+	}
+	if opts.ExactAllocated && (opts.ApparentSize || opts.HumanReadable || opts.BytesAndHuman || opts.UnitSize != "" ||
+		count(opts.BlockSize, opts.BlockSizeM, opts.BlockSizeG) > 0) {
+		errLog.Println("Cannot combine --exact-allocated with apparent sizes or other units.")
+		return true
+	}
 
 	return false
 }
 
+// count returns the number of `flags` that are set.
+func count(flags ...bool) int {
+	n := 0
+	for _, f := range flags {
+		if f {
+			n++
+		}
+	}
+
+	return n
+}
+
+// checkFlags validates the values of the command line flags. Returns an
+// error describing the first invalid value.
+func checkFlags() error {
+	if _, err := useColor(opts.Color, os.Stdout); err != nil {
+		return err
+	}
+	if opts.Interactive && !rawSupported {
+		return errors.New("--interactive is not supported on this system")
+	}
+	if opts.UseFrsize && !dirtree.FrsizeSupported {
+		return errors.New("--use-frsize is not supported on this system, it has no f_frsize")
+	}
+	if opts.DedupExtents && !dirtree.DedupExtentsSupported {
+		return errors.New("--dedup-extents is not supported by this build, it needs the fiemap build tag")
+	}
+	switch opts.Format {
+	case "text", "json", "ndjson", "csv":
+	default:
+		return fmt.Errorf("invalid argument '%s' for '--format', valid arguments are 'text', 'json', 'ndjson' and 'csv'", opts.Format)
+	}
+	if _, _, err := unitSize(); err != nil {
+		return err
+	}
+	if _, err := minSize(); err != nil {
+		return err
+	}
+	if _, err := changedSince(); err != nil {
+		return err
+	}
+	if _, _, err := mtimeRange(); err != nil {
+		return err
+	}
+	if _, err := sortKey(); err != nil {
+		return err
+	}
+	if opts.OpTimeout < 0 {
+		return fmt.Errorf("invalid argument '%v' for '--op-timeout', expecting a positive duration", opts.OpTimeout)
+	}
+	if opts.TimeThreshold < 0 {
+		return fmt.Errorf("invalid argument '%v' for '--time-threshold', expecting a positive duration", opts.TimeThreshold)
+	}
+	if _, err := humanStyle(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// unitSize returns the size of the units to write the sizes in and whether
+// they should be written in human readable format instead, based on the
+// command line flags and the environment, see resolveUnitSize.
+func unitSize() (int64, bool, error) {
+	return resolveUnitSize(opts, os.Getenv)
+}
+
+// The environment variables that set the unit size, from the highest
+// precedence to the lowest.
+var unitSizeEnv = []string{"DU_BLOCK_SIZE", "BLOCK_SIZE", "BLOCKSIZE"}
+
+// resolveUnitSize returns the size of the units to write the sizes in and
+// whether they should be written in human readable format instead. The
+// first one set of the following is used:
+//   - the -B (--block-size) flag
+//   - the -k (or --posix-1k), -m or -g flag
+//   - the DU_BLOCK_SIZE, BLOCK_SIZE and BLOCKSIZE environment variables,
+//     in this order, invalid values are ignored
+//   - the Posix default of 512 bytes
+//
+// All of them accept the same values as -B. The -h flag turns on human
+// readable format in any case, and so does --human-readable-si-bytes. The
+// units are single bytes then, like in GNU du the human readable sizes are
+// rounded from the exact numbers of bytes.
+func resolveUnitSize(o options, getenv func(string) string) (int64, bool, error) {
+	if o.ExactAllocated {
+		return 1, false, nil
+	}
+	size, human, err := configuredUnitSize(o, getenv)
+	if human {
+		size = 1
+	}
+
+	return size, human, err
+}
+
+// configuredUnitSize returns the unit size set by the flags or the
+// environment, see resolveUnitSize.
+func configuredUnitSize(o options, getenv func(string) string) (int64, bool, error) {
+	human := o.HumanReadable || o.BytesAndHuman
+	if o.UnitSize != "" {
+		size, h, err := parseUnitSize(o.UnitSize)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid argument '%s' for '--block-size'", o.UnitSize)
+		}
+		return size, h || human, nil
+	}
+	switch {
+	case o.BlockSize || o.Posix1K:
+		return 1024, human, nil
+	case o.BlockSizeM:
+		return 1 << 20, human, nil
+	case o.BlockSizeG:
+		return 1 << 30, human, nil
+	}
+	for _, name := range unitSizeEnv {
+		if v := getenv(name); v != "" {
+			if size, h, err := parseUnitSize(v); err == nil {
+				return size, h || human, nil
+			}
+		}
+	}
+
+	return 512, human, nil
+}
+
+// parseUnitSize parses a value of the -B flag: either a positive size (see
+// parseSize) or "human" (or "human-readable") for human readable format.
+func parseUnitSize(v string) (int64, bool, error) {
+	if v == "human" || v == "human-readable" {
+		return 512, true, nil
+	}
+	size, err := parseSize(v)
+	if err != nil {
+		return 0, false, err
+	}
+	if size <= 0 {
+		return 0, false, fmt.Errorf("size %s is not positive", v)
+	}
+
+	return size, false, nil
+}
+
+// sortKey returns the value of the --sort flag.
+func sortKey() (dirtree.SortKey, error) {
+	switch opts.Sort {
+	case "", "none":
+		return dirtree.SortNone, nil
+	case "size":
+		return dirtree.SortSize, nil
+	case "mtime":
+		return dirtree.SortMtime, nil
+	case "count":
+		return dirtree.SortCount, nil
+	}
+	return dirtree.SortNone, fmt.Errorf("invalid argument '%s' for '--sort', valid arguments are 'none', 'size', 'mtime' and 'count'", opts.Sort)
+}
+
+// humanStyle returns the value of the --human-style flag.
+func humanStyle() (dirtree.HumanStyle, error) {
+	switch opts.HumanStyle {
+	case "", "short":
+		return dirtree.HumanShort, nil
+	case "iec":
+		return dirtree.HumanIEC, nil
+	case "space":
+		return dirtree.HumanSpace, nil
+	}
+	return dirtree.HumanShort, fmt.Errorf("invalid argument '%s' for '--human-style', valid arguments are 'short', 'iec' and 'space'", opts.HumanStyle)
+}
+
+// minSize returns the value of the --min-size flag in bytes. The flag is in
+// the same units as the output or in bytes if the output is human readable.
+func minSize() (int64, error) {
+	if opts.MinSize == "" {
+		return 0, nil
+	}
+	size, err := parseSize(opts.MinSize)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid argument '%s' for '--min-size'", opts.MinSize)
+	}
+	bs, human, err := unitSize()
+	if err != nil || human {
+		return size, err
+	}
+	if size > math.MaxInt64/bs {
+		return 0, fmt.Errorf("invalid argument '%s' for '--min-size'", opts.MinSize)
+	}
+
+	return size * bs, nil
+}
+
+// changedSince returns the modification time of the file given with
+// --changed-since-file, or the zero time if there is none.
+func changedSince() (time.Time, error) {
+	if opts.ChangedSinceFile == "" {
+		return time.Time{}, nil
+	}
+	info, err := os.Stat(opts.ChangedSinceFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
+
+// mtimeRange returns the bounds given with --mtime-between, the zero time
+// for the ones left out.
+func mtimeRange() (time.Time, time.Time, error) {
+	var from, to time.Time
+	if opts.MtimeBetween == "" {
+		return from, to, nil
+	}
+	bounds := strings.SplitN(opts.MtimeBetween, ",", 2)
+	if len(bounds) != 2 {
+		return from, to, fmt.Errorf("invalid argument '%s' for '--mtime-between', expecting START,END", opts.MtimeBetween)
+	}
+	var err error
+	if bounds[0] != "" {
+		if from, err = time.Parse(time.RFC3339, bounds[0]); err != nil {
+			return from, to, fmt.Errorf("invalid argument '%s' for '--mtime-between': %v", opts.MtimeBetween, err)
+		}
+	}
+	if bounds[1] != "" {
+		if to, err = time.Parse(time.RFC3339, bounds[1]); err != nil {
+			return from, to, fmt.Errorf("invalid argument '%s' for '--mtime-between': %v", opts.MtimeBetween, err)
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return from, to, fmt.Errorf("invalid argument '%s' for '--mtime-between', END is before START", opts.MtimeBetween)
+	}
+
+	return from, to, nil
+}
+
+// parseSize parses a size such as 1024, 4K or 1M and returns it in bytes.
+// The suffixes K, M, G, T, P and E are powers of 1024.
+func parseSize(s string) (int64, error) {
+	var mult int64 = 1
+	if s != "" {
+		if i := strings.Index("KMGTPE", strings.ToUpper(s[len(s)-1:])); i >= 0 {
+			mult = 1 << (10 * uint(i+1))
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt64/mult || n < math.MinInt64/mult {
+		return 0, fmt.Errorf("size %s is too big", s)
+	}
+
+	return n * mult, nil
+}
+
+// useColor decides whether the output should be coloured based on the
+// --color flag value and on whether `out` is a terminal. Returns an error
+// if `when` is not one of "auto", "always" or "never".
+func useColor(when string, out *os.File) (bool, error) {
+	switch when {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return isTerminal(int(out.Fd())), nil
+	}
+	return false, fmt.Errorf("invalid argument '%s' for '--color', valid arguments are 'auto', 'always' and 'never'", when)
+}
+
 // printVersion prints out version, license and contact information.
 func printVersion() {
 	fmt.Println("go-du", version)
@@ -94,56 +678,895 @@ func printVersion() {
 func init() {
 	// Define command-line flags
 	flag.Usage = func() {
-		fmt.Println("Usage: go-du [-a|-s] [-kx] [-H|-L] [FILE...]")
+		fmt.Println("Usage: go-du [-a|-s] [-hx] [-k|-m|-g] [-H|-L|-P] [OPTION]... [FILE...]")
 		fmt.Println("Summarise disk usage of the set of FILEs, recursively for directories.")
 		fmt.Println()
 		flag.PrintDefaults()
 		fmt.Println("\nThis is POSIX compatible implementation of the du utility. For exended")
 		fmt.Println("documentation see https://man7.org/linux/man-pages/man1/du.1p.html")
 		fmt.Println("\nDisplay values are in 512-byte units, rounded up to the next 512-byte unit")
-		fmt.Println("unless -k flag is specified.")
+		fmt.Println("unless -k, -m, -g, -B or one of the DU_BLOCK_SIZE, BLOCK_SIZE and BLOCKSIZE")
+		fmt.Println("environment variables is specified.")
+		fmt.Println("\nExit status is 0 on success, 1 if some files or directories could not be")
+		fmt.Println("read, 2 for invalid arguments and 3 if an argument could not be scanned at")
+		fmt.Println("all or the scan could not be completed.")
 		fmt.Println("\nCreated by Ilia Frenkel<frenkel.ilia@gmail.com>")
 		fmt.Println("Report bugs at https://github.com/iliafrenkel/go-du")
 		fmt.Printf("Revision: %s\n", revision)
 	}
 	flag.BoolVar(&opts.BlockSize, "k", false, "\tWrite the files sizes in units of 1024 bytes, rather than the\n\tdefault 512-byte units")
+	flag.StringVar(&opts.ChangedSinceFile, "changed-since-file", "", "\tcount only the files modified after FILE was, e.g., to find out how\n\tbig an incremental backup made since 'touch FILE' would be; the\n\tdirectories are still counted")
+	flag.StringVar(&opts.Color, "color", "never", "\tcolour the sizes of big entries; WHEN is 'auto', 'always' or 'never'")
+	flag.BoolVar(&opts.BlockSizeM, "m", false, "\twrite the sizes in units of 1M")
+	flag.BoolVar(&opts.BlockSizeG, "g", false, "\twrite the sizes in units of 1G")
+	flag.BoolVar(&opts.Breakdown, "breakdown", false, "\tprint only the total for each argument split into the size of the\n\tfiles and the size of the directories themselves")
+	flag.BoolVar(&opts.ByGroup, "by-group", false, "\tlike --by-owner, but print the totals of each group of the files\n\tand directories, e.g., for the quotas on shared storage")
+	flag.BoolVar(&opts.ByOwner, "by-owner", false, "\tprint only the total size of the files and directories of each owner\n\tfor each argument, the biggest first, with the user names where\n\tthey are known and the user ids otherwise")
+	flag.StringVar(&opts.HumanStyle, "human-style", "short", "\thow the suffixes of the human readable sizes are written; STYLE is\n\t'short' (4.0K), 'iec' (4.0KiB) or 'space' (4.0 K)")
+	flag.BoolVar(&opts.BytesAndHuman, "human-readable-si-bytes", false, "\tprint the sizes in bytes followed by a column with the same sizes\n\tin human readable format, like -h")
+	flag.BoolVar(&opts.Compression, "compression-ratio", false, "\tprint the allocated and the apparent sizes of each directory and\n\tthe ratio between them, which shows the savings of filesystem\n\tcompression")
 	flag.BoolVar(&opts.CountFiles, "a", false, "\twrite counts for all files, not just directories")
+	flag.BoolVar(&opts.Flat, "flat", false, "\tlist only the files, each with its size and full path, without the\n\ttotals of the directories, not even of the arguments; an argument\n\tthat is a file is listed itself")
+	flag.BoolVar(&opts.Canonicalize, "canonicalize", false, "\tremove the redundant '.', '..' and path separators from the arguments\n\tbefore scanning them (e.g., a/../b/ is b), so that all the paths in the\n\toutput and the errors are clean")
+	flag.BoolVar(&opts.CollapseOperands, "collapse-operands", false, "\tskip the arguments that are inside the directories given as other\n\targuments, so that nothing is counted twice")
+	flag.BoolVar(&opts.Combine, "combine", false, "\ttreat all the arguments as one tree: count files with multiple hard\n\tlinks once across all of them and print their combined total last")
+	flag.BoolVar(&opts.CurrentSummary, "current-summary", false, "\twith no arguments, print only the total of the current directory,\n\tlike -s . does; without it all its sub-directories are printed too")
+	flag.Int64Var(&opts.DentryOverhead, "dentry-overhead", 0, "\tadd N bytes for each entry of a directory to its size, as a rough\n\testimate of the space taken by the names (experimental)")
+	flag.BoolVar(&opts.DebugBlocks, "debug-blocks", false, "\tprint the number of 512-byte blocks the file system reports allocated\n\tto each file and directory (st_blocks) in a column after the size,\n\tto check the sizes against")
+	flag.BoolVar(&opts.DedupExtents, "dedup-extents", false, "\tcount the extents shared by several files, e.g. the reflink copies on\n\tBtrfs or XFS, only once, for the first file they are found in; this\n\tis experimental, slow and only available if built with the fiemap tag")
+	flag.BoolVar(&opts.DedupOperands, "dedup-operands", false, "\tcount files with multiple hard links only once across all the\n\targuments, rather than once for each argument")
 	flag.BoolVar(&opts.DereferenceAll, "L", false, "\tdereference all symbolic links")
 	flag.BoolVar(&opts.DereferenceArgs, "H", false, "\tdereference only symlinks that are listed on the command line")
-	flag.BoolVar(&opts.OneFileSystem, "x", false, "\tskip directories on different file systems")
+	flag.IntVar(&opts.DerefDepth, "deref-depth", 0, "\tdereference the symbolic links listed on the command line and the ones\n\tfound up to N levels below them, but not the deeper ones; -H is like\n\tN=0 and -L like no limit")
+	flag.StringVar(&opts.Diff, "diff", "", "\tprint how the sizes of the directories have changed since the scan\n\tsaved in FILE with --format=json")
+	flag.BoolVar(&opts.ExactAllocated, "exact-allocated", false, "\tprint the exact number of bytes allocated to the files and\n\tdirectories (st_blocks*512), without estimating it from the block\n\tsize or converting it to units")
+	flag.BoolVar(&opts.ExcludeDotfiles, "exclude-dotfiles", false, "\tskip hidden files and directories, the ones with the name that starts\n\twith a dot")
+	flag.BoolVar(&opts.ExcludeVCS, "exclude-vcs", false, "\tskip the metadata directories of the version control systems: .git,\n\t.svn, .hg, .bzr and CVS")
+	flag.Var(&opts.Exclude, "exclude", "\texclude files that match PATTERN, can be given multiple times")
+	flag.BoolVar(&opts.FailFast, "fail-fast", false, "\tstop at the first file or directory that cannot be read instead of\n\treporting it and going on")
+	flag.BoolVar(&opts.FromStdin, "from-stdin", false, "\tread the files to scan from stdin, one per line, instead of the\n\tcommand line (e.g., find . -name '*.log' | go-du -s --from-stdin)")
+	flag.StringVar(&opts.Format, "format", "text", "\toutput format; FORMAT is 'text', 'json' (a JSON tree for each\n\targument) 'ndjson' (a JSON object for each entry) or 'csv' (a record of the\n\tsize and the path for each entry)")
+	flag.BoolVar(&opts.GitIgnore, "gitignore", false, "\tskip files and directories ignored by the .gitignore files found\n\tduring the traversal")
+	flag.BoolVar(&opts.DepthSummary, "depth-summary", false, "\tprint the number of the directories at each depth, from the argument\n\tat 0 down to the deepest one, and their total size")
+	flag.BoolVar(&opts.Histogram, "histogram", false, "\tprint how many files there are in each range of sizes (<4K, 4K-64K,\n\t64K-1M and >=1M) and how much space they take")
+	flag.BoolVar(&opts.HumanReadable, "h", false, "\tprint sizes in human readable format (e.g., 1.5K, 234M, 2.0G)")
+	flag.IntVar(&opts.Jobs, "jobs", 0, "\tscan up to N arguments at the same time, each in a tree of its own;\n\tthe default is the number of CPUs, but at most 4 so that spinning\n\tdisks are not thrashed; --jobs=1 scans them one after another")
+	flag.BoolVar(&opts.Interactive, "interactive", false, "\tbrowse the results interactively with the arrow keys")
+	flag.IntVar(&opts.LeafDepth, "leaf-depth", -1, "\tprint the total for a directory only if it is N levels above the\n\tdeepest directory under it, counting from the leaves instead of\n\tthe argument; --leaf-depth=0 prints only the leaf directories")
+	opts.MaxDepth = -1
+	flag.Var(&opts.MaxDepth, "d", "\tsame as --max-depth")
+	flag.IntVar(&opts.MaxResults, "max-results", 0, "\tprint at most N entries and tell on stderr how many more there are,\n\tso that a huge tree doesn't flood the terminal; the scan and the\n\ttotals are not affected")
+	flag.Var(&opts.MaxDepth, "max-depth", "\tprint the total for a directory (or file, with -a) only if it is N\n\tor fewer levels below the argument; --max-depth=0 is the same as -s,\n\t'inf', 'unlimited' or a negative N mean no limit")
+	flag.StringVar(&opts.MtimeBetween, "mtime-between", "", "\tcount only the files modified between START and END, inclusive,\n\tgiven as START,END in RFC3339 (e.g., 2021-01-01T00:00:00Z); either\n\tof them can be left out; the directories are still counted")
+	flag.StringVar(&opts.MinSize, "min-size", "", "\tdo not print entries smaller than SIZE, which is in the same units as\n\tthe output (bytes with -h, e.g., 100K); the totals are not affected")
+	flag.BoolVar(&opts.Null, "0", false, "\tend each output line with NUL, not newline, e.g. for xargs -0")
+	flag.BoolVar(&opts.Merge, "merge", false, "\tprint each path only once, with the largest size found for it, when\n\tthe arguments overlap; nothing is printed before all of them are\n\tscanned")
+	flag.BoolVar(&opts.Merge, "merge-output", false, "\tsame as --merge")
+	flag.BoolVar(&opts.NoSmallFileRounding, "no-block-rounding-for-small-files", false, "\tcount the apparent sizes of the files smaller than a block instead of\n\ta whole block for each, to approximate the file systems that pack\n\tthe tails of the files together, e.g., for a maildir")
+	flag.BoolVar(&opts.NormalizeUnicode, "normalize-unicode", false, "\twrite the paths in the Unicode normalization form C (NFC), so that\n\tthe names stored decomposed (NFD), e.g., on HFS+, compare equal to\n\tthe same names written elsewhere; the files are not affected")
+	flag.BoolVar(&opts.NoDereference, "P", false, "\tdon't follow any symbolic links, not even the ones listed on the\n\tcommand line (this is the default)")
+	flag.BoolVar(&opts.OnlyDotfiles, "only-dotfiles", false, "\tcount only hidden files and directories and the content of the hidden\n\tdirectories, skip everything else")
+	flag.BoolVar(&opts.OneFilePerOperand, "one-file-per-operand", false, "\tcount files with multiple hard links once for each argument they are\n\tfound under, rather than once across all of them; this is the\n\tdefault, see --dedup-operands")
+	flag.BoolVar(&opts.OneFileSystem, "x", false, "\tskip directories on different file systems; this is the default for /")
+	flag.BoolVar(&opts.CrossFileSystems, "cross-file-systems", false, "\tscan the file systems mounted under / as well, like /proc or /sys,\n\twhen / is one of the arguments, instead of skipping them as -x does")
+	flag.IntVar(&opts.PadSizes, "pad-sizes", 0, "\tpad the sizes with spaces on the left to at least N characters, so that\n\tthe output can be split on fixed columns; human readable sizes are\n\tnot padded")
+	flag.BoolVar(&opts.PathsOnly, "paths-only", false, "\tprint only the paths of the files and directories that would be\n\tprinted, without the sizes")
+	flag.BoolVar(&opts.Posix1K, "posix-1k", false, "\twrite the sizes in 1024-byte units, separated from the paths by a\n\tsingle space, without colours, exactly as POSIX specifies the output\n\tof du -k, e.g. to reconcile with df -P")
+	flag.BoolVar(&opts.PortableOutput, "portable-output", false, "\tseparate the directories in the printed paths with '/' whatever the\n\toperating system uses, so that the reports of different systems can\n\tbe compared")
+	flag.StringVar(&opts.Prefix, "prefix", "", "\tprefix the printed paths with PATH, e.g., with --prefix=/backup\n\t'./sub' is printed as '/backup/sub', for the trees scanned in a\n\tstaging directory or a chroot; the files are not affected")
+	flag.StringVar(&opts.Prefix, "resolve-relative-to", "", "\tsame as --prefix")
+	flag.BoolVar(&opts.Pretty, "pretty", false, "\twith --format=json, indent the output for reading it rather than\n\tpiping it on")
+	flag.BoolVar(&opts.PrintRealPath, "print-real-path", false, "\twith -H or -L, print the paths the dereferenced symbolic links\n\tresolve to instead of the paths of the links")
+	flag.BoolVar(&opts.Progress, "progress", false, "\tshow the progress of the scan on stderr")
+	flag.BoolVar(&opts.RegularFilesOnly, "count-only-regular-files", false, "\tcount only the space allocated to the regular files, not to the\n\tdirectories themselves, the symbolic links or the special files, e.g.,\n\tto estimate the size of a backup")
+	flag.BoolVar(&opts.ResolveMounts, "resolve-mountpoints", false, "\tmark the directories that are mount points, the ones on another\n\tfile system than their parent, with [mount] after the path")
+	flag.Var(&opts.ReportInaccessible, "report-inaccessible", "\tonce the scan is done, list the files and directories that could not\n\tbe read with their errors, so that it is clear what is missing from\n\tthe totals; to stderr, or to FILE with --report-inaccessible=FILE")
+	flag.IntVar(&opts.Retry, "retry", 0, "\tretry reading a file or a directory up to N times, waiting longer\n\teach time, if it fails with an error that may go away (e.g., EIO or\n\tETIMEDOUT on a network file system)")
+	flag.DurationVar(&opts.TimeThreshold, "time-threshold", 0, "\tmark the directories with nothing in them modified for DURATION\n\t(e.g., 2160h for 90 days) with [stale] after the path, as cold data\n\tto archive")
+	flag.DurationVar(&opts.OpTimeout, "op-timeout", 0, "\tgive up reading a directory or a file after DURATION (e.g., 10s),\n\treport it and go on, so that a hung network path doesn't stall the\n\twhole scan")
+	flag.DurationVar(&opts.ScanTimeout, "scan-timeout", 0, "\tstop scanning after DURATION (e.g., 30s, 5m), print the sizes scanned\n\tso far and exit with an error")
+	flag.BoolVar(&opts.SkipEmptyDirs, "skip-empty-dirs", false, "\tdo not print the directories that have no regular files anywhere in\n\tthem, e.g., the ones with only empty sub-directories, for cleanup\n\treports; the totals of the arguments are always printed")
+	flag.BoolVar(&opts.ShowCounts, "show-counts", false, "\tprint the number of files in each directory, including the ones in\n\tits sub-directories, in a column after the size")
+	flag.StringVar(&opts.Sort, "sort", "none", "\torder the files and directories within each directory and the\n\targuments; WORD is 'none' (the order they are read in), 'size'\n\t(the largest first) or 'mtime' (the most recently modified first,\n\ta directory by the latest modification of anything in it) or\n\t'count' (the most files first, with --show-counts)")
+	flag.BoolVar(&opts.Reverse, "r", false, "\treverse the order of --sort, e.g., the smallest or the oldest first")
+	flag.BoolVar(&opts.Reverse, "reverse", false, "\tsame as -r")
+	flag.BoolVar(&opts.SummarizeChildren, "summarize-with-children-count", false, "\twith -s, print the number of the files and directories directly in\n\teach argument in a column after the size, e.g., '12 items'")
+	flag.BoolVar(&opts.SummarizePercent, "summarize-percent", false, "\tprint the size of each file and directory as a percentage of the\n\tdirectory it is in instead, e.g., 45%, to spot what takes the most\n\tspace; the arguments are 100%")
+	flag.StringVar(&opts.SummaryOut, "summary-out", "", "\twrite the total of each argument to FILE as well, in bytes followed by\n\ta space and the path, whatever is printed to the standard output")
 	flag.BoolVar(&opts.Summarise, "s", false, "\tdisplay only a total for each argument")
+	flag.IntVar(&opts.Throttle, "throttle", 0, "\tread at most about N directories per second to limit the load on\n\tshared storage")
+	flag.IntVar(&opts.Truncate, "truncate", 0, "\tshorten the paths longer than N characters by leaving out the\n\tdirectories in the middle (e.g., a/b/.../y/z); JSON is not affected")
+	flag.BoolVar(&opts.TotalsOnly, "total-for-root-only", false, "\tprint only the total for each argument and nothing else, even with -a")
+	flag.BoolVar(&opts.TotalAtTop, "total-at-top", false, "\tprint the total for each argument before its content")
+	flag.BoolVar(&opts.ApparentSize, "apparent-size", false, "\tprint apparent sizes (the number of bytes in the files) rather than\n\tthe disk usage")
+	flag.StringVar(&opts.UnitSize, "B", "", "\twrite the sizes in units of SIZE bytes (e.g., 1024, 4K, 1M), 'human'\n\tis the same as -h; overrides -k, -m, -g and the DU_BLOCK_SIZE,\n\tBLOCK_SIZE and BLOCKSIZE environment variables")
+	flag.StringVar(&opts.UnitSize, "block-size", "", "\tsame as -B")
+	flag.BoolVar(&opts.UseFrsize, "use-frsize", false, "\tcalculate the disk usage with the fundamental block size of the\n\tfilesystems (f_frsize) rather than the optimal transfer block size\n\t(f_bsize); they are the same on most filesystems")
+	flag.BoolVar(&opts.Verify, "verify", false, "\tscan each argument once more after the first scan and warn if its\n\ttotal has changed by more than 1% in between, which means the sizes\n\tare approximate")
+	flag.BoolVar(&opts.VerboseErrors, "verbose-errors", false, "\tprint errors with the path that caused them, in the style of GNU du")
 	flag.BoolVar(&opts.Version, "version", false, "\t")
 	flag.BoolVar(&opts.Version, "v", false, "\tshow version info and exit")
+	flag.Var(&opts.ZeroByteReport, "zero-byte-report", "\tprint the number of the regular files with no data in them after the\n\toutput of each argument, as lots of them are often a sign of a bug;\n\twith --zero-byte-report=list print their paths before it as well")
+	flag.IntVar(&opts.ZeroPadSizes, "zero-pad-sizes", 0, "\tlike --pad-sizes, but pad the sizes with zeros (e.g., 000123)")
 	flag.Parse()
 
+	// Before the checks, so that it conflicts with the same flags as -s
+	summariseCurrent(flag.Args())
 	if conflictingFlags() {
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+	if err := checkFlags(); err != nil {
+		errLog.Println(err)
+		os.Exit(exitUsage)
 	}
 
 	// If version is requested print out the info and ignore all other flags
 	if opts.Version {
 		printVersion()
-		os.Exit(0)
+		os.Exit(exitOK)
 	}
 }
 
 func main() {
-	// If there are no arguments provided, default to the current directory
-	argFiles = flag.Args()
-	if len(argFiles) == 0 {
-		argFiles = append(argFiles, ".")
+	var err error
+	if argFiles, err = operands(flag.Args(), os.Stdin); err != nil {
+		errLog.Println(err)
+		os.Exit(exitUsage)
+	}
+
+	if opts.Interactive {
+		if err := browse(argFiles); err != nil {
+			errLog.Println(err)
+			os.Exit(exitFatal)
+		}
+		return
+	}
+
+	if err := run(argFiles, os.Stdout); err != nil {
+		if err != errScan && err != errOperand {
+			errLog.Println(err)
+		}
+		os.Exit(exitCode(err))
 	}
+}
+
+// operands returns the files to scan: the command line arguments `args`, or
+// the lines read from `stdin` with --from-stdin. If there are no arguments
+// provided, default to the current directory, and summarise it with
+// --current-summary.
+func operands(args []string, stdin io.Reader) ([]string, error) {
+	files := args
+	if opts.FromStdin {
+		if len(files) > 0 {
+			return nil, fmt.Errorf("extra operand '%s', file operands cannot be combined with --from-stdin", files[0])
+		}
+		var err error
+		if files, err = readOperands(stdin); err != nil {
+			return nil, err
+		}
+	} else if len(files) == 0 {
+		files = []string{"."}
+	}
+	if opts.Canonicalize {
+		files = canonicalOperands(files)
+	}
+	if opts.CollapseOperands {
+		files = collapseOperands(files)
+	}
+
+	return files, nil
+}
+
+// summariseCurrent turns --current-summary into -s if there are no command
+// line arguments `args`, that is if the current directory is scanned.
+func summariseCurrent(args []string) {
+	if opts.CurrentSummary && len(args) == 0 && !opts.FromStdin {
+		opts.Summarise = true
+	}
+}
+
+// canonicalOperands returns the `files` with the redundant ".", ".." and
+// separators removed, see filepath.Clean. Relative paths stay relative.
+func canonicalOperands(files []string) []string {
+	out := make([]string, len(files))
+	for i, f := range files {
+		out[i] = filepath.Clean(f)
+	}
+
+	return out
+}
+
+// collapseOperands returns the `files` without the ones that are inside
+// another one of them, so that nothing is counted twice. The paths are
+// compared as they are, without resolving symbolic links, and the order of
+// the rest is kept. Of the same path given more than once only the first one
+// is kept.
+func collapseOperands(files []string) []string {
+	abs := make([]string, len(files))
+	for i, f := range files {
+		if a, err := filepath.Abs(f); err == nil {
+			abs[i] = a
+		} else {
+			abs[i] = filepath.Clean(f)
+		}
+	}
+	var out []string
+	for i, f := range files {
+		if !nested(abs, i) {
+			out = append(out, f)
+		}
+	}
+
+	return out
+}
+
+// nested reports whether `paths[i]` is inside one of the other `paths` or
+// is the same as one of the paths before it.
+func nested(paths []string, i int) bool {
+	for j, p := range paths {
+		if j == i {
+			continue
+		}
+		if paths[i] == p {
+			if j < i {
+				return true
+			}
+			continue
+		}
+		// The root directory is the only one that ends with a separator
+		dir := p
+		if !strings.HasSuffix(dir, string(filepath.Separator)) {
+			dir += string(filepath.Separator)
+		}
+		if strings.HasPrefix(paths[i], dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readOperands reads the files to scan from `r`, one per line. Blank lines
+// are ignored.
+func readOperands(r io.Reader) ([]string, error) {
+	var files []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSuffix(scanner.Text(), "\r"); line != "" {
+			files = append(files, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read the files from stdin: %v", err)
+	}
+
+	return files, nil
+}
+
+// newScanner returns a scanner configured according to the command line
+// flags.
+func newScanner() *dirtree.Scanner {
+	// The flags are already validated so the error can be ignored
+	bs, _, _ := unitSize()
+	scanner := dirtree.NewScanner(bs)
+	scanner.Exclude = opts.Exclude
+	if opts.ExcludeVCS {
+		scanner.Exclude = append(scanner.Exclude, dirtree.VCSPatterns...)
+	}
+	scanner.GitIgnore = opts.GitIgnore
+	scanner.ApparentSize = opts.ApparentSize
+	scanner.UseFrsize = opts.UseFrsize
+	scanner.ExactAllocated = opts.ExactAllocated
+	scanner.TailPacking = opts.NoSmallFileRounding
+	scanner.DedupExtents = opts.DedupExtents
+	scanner.ExcludeDotfiles = opts.ExcludeDotfiles
+	scanner.OnlyDotfiles = opts.OnlyDotfiles
+	// The files are only ever printed with -a
+	scanner.SkipFiles = (!opts.CountFiles || opts.TotalsOnly) && !opts.Histogram && !opts.Flat
+	scanner.RealPaths = opts.PrintRealPath
+	scanner.VerboseErrors = opts.VerboseErrors
+	scanner.FailFast = opts.FailFast
+	scanner.Throttle = opts.Throttle
+	scanner.Retry = opts.Retry
+	scanner.OpTimeout = opts.OpTimeout
+	scanner.DentryOverhead = opts.DentryOverhead
+	scanner.RegularFilesOnly = opts.RegularFilesOnly
+	scanner.ChangedSince, _ = changedSince()
+	scanner.ModifiedFrom, scanner.ModifiedTo, _ = mtimeRange()
+	scanner.ByOwner = opts.ByOwner
+	scanner.ByGroup = opts.ByGroup
+	scanner.ZeroByteFiles = opts.ZeroByteReport != ""
+	switch {
+	case opts.DereferenceAll || opts.DerefDepth > 0:
+		scanner.Dereference = dirtree.DerefAll
+		scanner.DerefDepth = opts.DerefDepth
+	case opts.DereferenceArgs:
+		scanner.Dereference = dirtree.DerefArgs
+	default:
+		scanner.Dereference = dirtree.DerefNone
+	}
+	if opts.Progress {
+		scanner.Progress = progressPrinter(os.Stderr)
+	}
+
+	return scanner
+}
+
+// progressPrinter returns a function that prints out the progress of a scan
+// to `w`, each time over the previous one.
+func progressPrinter(w io.Writer) func(float64) {
+	last := -1
+	return func(percent float64) {
+		if p := int(percent); p != last {
+			last = p
+			fmt.Fprintf(w, "\rScanning... %3d%%", p)
+		}
+	}
+}
+
+// clearProgress removes the progress line printed by progressPrinter.
+func clearProgress(w io.Writer) {
+	if opts.Progress {
+		fmt.Fprint(w, "\r\033[K")
+	}
+}
+
+// printOptions returns the options for printing out the results according to
+// the command line flags.
+func printOptions() dirtree.PrintOptions {
+	// The flags are already validated so the errors can be ignored
+	_, human, _ := unitSize()
+	color, _ := useColor(opts.Color, os.Stdout)
+	min, _ := minSize()
+	sort, _ := sortKey()
+	style, _ := humanStyle()
+	format := outFormat
+	if opts.PathsOnly {
+		format = pathFormat
+	}
+	if opts.Posix1K {
+		format, color = posixFormat, false
+	}
+	var stale time.Time
+	if opts.TimeThreshold > 0 {
+		stale = time.Now().Add(-opts.TimeThreshold)
+	}
+	var formatter dirtree.Formatter
+	if opts.Format == "csv" {
+		formatter, _ = dirtree.LookupFormatter("csv")
+	}
+	pad := opts.PadSizes
+	if opts.ZeroPadSizes > 0 {
+		pad = opts.ZeroPadSizes
+	}
+
+	return dirtree.PrintOptions{
+		Format:           format,
+		CountFiles:       opts.CountFiles && !opts.TotalsOnly,
+		Summarise:        opts.Summarise || opts.MaxDepth == 0 || opts.TotalsOnly,
+		Color:            color,
+		TotalAtTop:       opts.TotalAtTop,
+		Human:            human,
+		HumanStyle:       style,
+		MinSize:          min,
+		MaxDepth:         int(opts.MaxDepth),
+		ByLeafDepth:      opts.LeafDepth >= 0,
+		LeafDepth:        opts.LeafDepth,
+		Bytes:            opts.BytesAndHuman,
+		Counts:           opts.ShowCounts,
+		Blocks:           opts.DebugBlocks,
+		Children:         opts.SummarizeChildren,
+		Sort:             sort,
+		Reverse:          opts.Reverse,
+		Truncate:         opts.Truncate,
+		PadWidth:         pad,
+		ZeroPad:          opts.ZeroPadSizes > 0,
+		Pretty:           opts.Pretty,
+		Mounts:           opts.ResolveMounts,
+		SkipEmpty:        opts.SkipEmptyDirs,
+		Portable:         opts.PortableOutput,
+		Flat:             opts.Flat,
+		Percent:          opts.SummarizePercent,
+		NormalizeUnicode: opts.NormalizeUnicode,
+		Prefix:           opts.Prefix,
+		StaleBefore:      stale,
+		Formatter:        formatter,
+	}
+}
+
+// errScan is returned by run when some of the files could not be scanned.
+// The errors themselves are already printed out by the scanner.
+var errScan = errors.New("some files could not be scanned")
+
+// errOperand is returned by run when some of the arguments could not be
+// scanned at all, for example because they don't exist.
+var errOperand = errors.New("some arguments could not be scanned")
+
+// The exit statuses of the program.
+const (
+	exitOK    = 0 // everything is scanned
+	exitScan  = 1 // some files or directories could not be read
+	exitUsage = 2 // invalid arguments, the same status the flag package uses
+	exitFatal = 3 // an argument could not be scanned or the scan failed
+)
+
+// exitCode returns the exit status for `err` returned by run.
+func exitCode(err error) int {
+	switch err {
+	case nil:
+		return exitOK
+	case errScan:
+		return exitScan
+	default:
+		return exitFatal
+	}
+}
+
+// run calculates the disk usage of each of the `files` and writes it out to
+// `out` according to the command line flags. Returns an error if it cannot
+// do it at all. The errors encountered while scanning the files are printed
+// out to stderr instead and errScan is returned once all the files are done,
+// or errOperand if some of the `files` themselves could not be scanned.
+func run(files []string, out io.Writer) error {
+	var previous []*dirtree.DirTree
+	if opts.Diff != "" {
+		var err error
+		if previous, err = readTrees(opts.Diff); err != nil {
+			return err
+		}
+	}
+
+	if opts.MaxResults > 0 {
+		limit := &resultLimit{w: out, max: opts.MaxResults}
+		defer limit.notice()
+		out = limit
+	}
+	if opts.Merge {
+		merged := &mergedLines{w: out, index: make(map[string]int)}
+		defer merged.flush()
+		out = merged
+	}
+
+	var summary io.Writer
+	if opts.SummaryOut != "" {
+		f, err := os.Create(opts.SummaryOut)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		summary = f
+	}
+	var inaccessible []*dirtree.ScanError
+	if opts.ReportInaccessible != "" {
+		report := io.Writer(os.Stderr)
+		if opts.ReportInaccessible != "-" {
+			f, err := os.Create(string(opts.ReportInaccessible))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			report = f
+		}
+		// Also after a failed or timed out scan, that is when it matters
+		// the most
+		defer func() { printInaccessible(inaccessible, report) }()
+	}
+
+	for _, file := range files {
+		if oneFileSystem(file) && !opts.OneFileSystem {
+			errLog.Println("Skipping the file systems mounted under '/', use --cross-file-systems to scan them too.")
+			break
+		}
+	}
+
+	popts := printOptions()
+	scanner := newScanner()
+	// Plain text is printed while scanning, so that the output of huge
+	// trees starts straight away, unless it depends on the whole tree.
+	stream := opts.Format == "text" && opts.Diff == "" && !opts.Progress && !popts.Human && !popts.TotalAtTop &&
+		popts.Sort == dirtree.SortNone && !opts.Compression && !opts.Breakdown &&
+		!opts.Histogram && !opts.DepthSummary && !opts.ByOwner && !opts.ByGroup && !popts.ByLeafDepth && !popts.SkipEmpty &&
+		!popts.Percent
+	// The arguments are printed in order unless they have to be sorted
+	// once all of them are scanned. With --total-at-top the combined total
+	// comes first, so they are held back until it is known as well.
+	totalFirst := opts.Combine && opts.Diff == "" && popts.TotalAtTop
+	sorted := opts.Diff == "" && (popts.Sort != dirtree.SortNone || totalFirst)
+	if stream {
+		scanner.FilesDone = func(dt *dirtree.DirTree) { printLines(dt.PrintFiles(popts), out) }
+		scanner.DirDone = func(dt *dirtree.DirTree) { printLines(dt.PrintDir(popts), out) }
+	}
+	ctx := context.Background()
+	if opts.ScanTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.ScanTimeout)
+		defer cancel()
+	}
+	// The arguments are scanned in parallel only if they don't depend on
+	// each other and the output doesn't depend on the order of the scans.
+	var scans []*scanJob
+	if n := jobs(); n > 1 && len(files) > 1 && !stream && !opts.DedupOperands && !opts.Combine &&
+		!opts.FailFast && !opts.Progress && opts.Throttle == 0 {
+		scans = scanAll(ctx, files, n)
+	}
+	var trees []*dirtree.DirTree
+	timedOut, missing, failed := false, false, false
+	for i, file := range files {
+		s := scanner
+		var dt *dirtree.DirTree
+		var err error
+		// The errors of this argument, the scanner may have more
+		reported := 0
+		// What was counted before this argument, to verify it with
+		var counted dirtree.Counted
+		if scans != nil {
+			<-scans[i].done
+			s, dt, err = scans[i].scanner, scans[i].dt, scans[i].err
+		} else {
+			// Files with multiple hard links are counted once for each
+			// file operand they occur under, unless asked otherwise.
+			if !opts.DedupOperands && !opts.Combine {
+				scanner.Reset()
+			}
+			// Errors are reported by the scanner
+			reported = len(scanner.Errors())
+			if opts.Verify {
+				counted = scanner.Counted()
+			}
+			scanner.OneFileSystem = oneFileSystem(file)
+			dt, err = scanner.ScanContext(ctx, file)
+		}
+		inaccessible = append(inaccessible, s.Errors()[reported:]...)
+		unreadable := unreadableOperand(err, file)
+		missing = missing || unreadable
+		failed = failed || s.Failed()
+		clearProgress(os.Stderr)
+		if opts.FailFast && failed {
+			if missing {
+				return errOperand
+			}
+			return errScan
+		}
+		// Like GNU du, nothing is printed for the arguments that cannot be
+		// accessed, not even a zero size
+		if unreadable {
+			continue
+		}
+		if opts.Verify && ctx.Err() == nil {
+			verify(s, dt, counted, file)
+		}
+		if opts.Diff != "" {
+			printLines(dt.PrintDiff(dirtree.FindTree(previous, file), popts), out)
+		} else if !stream && !sorted {
+			printTree(dt, popts, out)
+		}
+		if !sorted {
+			printZeroByteFiles(dt, popts, out)
+		}
+		if summary != nil {
+			fmt.Fprintln(summary, dt.PrintTotal(popts))
+		}
+		trees = append(trees, dt)
+		if timedOut = ctx.Err() != nil; timedOut {
+			break
+		}
+	}
+	if totalFirst && !timedOut {
+		printCombined(trees, popts, out)
+	}
+	if sorted {
+		for _, dt := range dirtree.SortTrees(trees, popts.Sort, popts.Reverse) {
+			printTree(dt, popts, out)
+			printZeroByteFiles(dt, popts, out)
+		}
+	}
+	// What is scanned so far is printed out anyway
+	if timedOut {
+		return fmt.Errorf("the scan timed out after %v, the sizes are incomplete", opts.ScanTimeout)
+	}
+	if opts.Combine && opts.Diff == "" && !totalFirst {
+		printCombined(trees, popts, out)
+	}
+	if missing {
+		return errOperand
+	}
+	if failed {
+		return errScan
+	}
+
+	return nil
+}
+
+// printCombined writes the grand total of all the `trees` to `out`, for
+// --combine.
+func printCombined(trees []*dirtree.DirTree, popts dirtree.PrintOptions, out io.Writer) {
+	popts.Summarise = true
+	popts.MinSize = 0
+	printTree(dirtree.Combine("total", trees...), popts, out)
+}
+
+// printInaccessible writes the `errs` reported during the scan to `w`, one
+// line for each path that could not be read. On stderr the list is preceded
+// by a header to set it apart from the errors printed during the scan.
+func printInaccessible(errs []*dirtree.ScanError, w io.Writer) {
+	if len(errs) == 0 {
+		return
+	}
+	if w == os.Stderr {
+		fmt.Fprintln(w, "The following could not be read and are missing from the totals:")
+	}
+	for _, err := range errs {
+		fmt.Fprintln(w, err)
+	}
+}
+
+// maxDefaultJobs is the most arguments scanned at the same time by default.
+// Scanning more of them in parallel than that thrashes spinning disks rather
+// than making the scan any faster.
+const maxDefaultJobs = 4
+
+// numCPU returns the number of CPUs, it is replaced in tests.
+var numCPU = runtime.NumCPU
 
-	// If -k is provided set block size to 1024
-	var bs int64 = 512
-	if opts.BlockSize {
-		bs = 1024
+// jobs returns how many arguments can be scanned at the same time: the
+// --jobs flag if it is given, the number of CPUs up to maxDefaultJobs
+// otherwise.
+func jobs() int {
+	if opts.Jobs > 0 {
+		return opts.Jobs
 	}
+	if n := numCPU(); n < maxDefaultJobs {
+		return n
+	}
+
+	return maxDefaultJobs
+}
 
-	for _, file := range argFiles {
-		dt := dirtree.New(file, bs)
-		for _, s := range dt.PrintDirTree(outFormat, opts.CountFiles, opts.Summarise) {
-			fmt.Println(s)
+// scanJob is the scan of one of the arguments, see scanAll. The tree and
+// the error are set once `done` is closed.
+type scanJob struct {
+	scanner *dirtree.Scanner
+	dt      *dirtree.DirTree
+	err     error
+	done    chan struct{}
+}
+
+// scanAll starts scanning the `files`, up to `jobs` of them at the same time
+// in the order they are given, each with a scanner of its own. It returns
+// straight away with a job for each of the `files`.
+func scanAll(ctx context.Context, files []string, jobs int) []*scanJob {
+	scans := make([]*scanJob, len(files))
+	for i := range files {
+		scans[i] = &scanJob{scanner: newScanner(), done: make(chan struct{})}
+	}
+	sem := make(chan struct{}, jobs)
+	go func() {
+		for i, file := range files {
+			sem <- struct{}{}
+			go func(j *scanJob, file string) {
+				defer func() {
+					<-sem
+					close(j.done)
+				}()
+				j.scanner.OneFileSystem = oneFileSystem(file)
+				j.dt, j.err = j.scanner.ScanContext(ctx, file)
+			}(scans[i], file)
 		}
+	}()
+
+	return scans
+}
+
+// oneFileSystem reports whether the scan of `file` should not leave the
+// file system it starts on. This is the case with -x and for / itself,
+// unless --cross-file-systems is given, as its total hardly ever includes
+// the pseudo-filesystems like /proc and the network shares on purpose.
+func oneFileSystem(file string) bool {
+	return opts.OneFileSystem || (filepath.Clean(file) == "/" && !opts.CrossFileSystems)
+}
+
+// unreadableOperand reports whether `err` returned by the scan of `file`
+// means that `file` itself could not be accessed.
+func unreadableOperand(err error, file string) bool {
+	var serr *dirtree.ScanError
+	return errors.As(err, &serr) && serr.Op == "access" && serr.Path == file
+}
+
+// readTrees reads the trees saved with --format=json from `file`.
+func readTrees(file string) ([]*dirtree.DirTree, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	trees, err := dirtree.ReadJSON(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read '%s': %v", file, err)
 	}
+
+	return trees, nil
+}
+
+// printTree writes out `dt` to `out` in the format given by the --format
+// flag.
+func printTree(dt *dirtree.DirTree, popts dirtree.PrintOptions, out io.Writer) {
+	switch opts.Format {
+	case "json":
+		// The tree is written while it is encoded, only the indented one
+		// is built in memory first
+		if popts.Pretty {
+			printLines(dt.PrintJSON(popts), out)
+		} else if err := dt.WriteJSON(out, popts); err != nil {
+			errLog.Println(err)
+		} else {
+			fmt.Fprint(out, lineEnd())
+		}
+	case "ndjson":
+		printLines(dt.PrintJSONLines(popts), out)
+	case "csv":
+		printLines(dt.Print(popts), out)
+	default:
+		if opts.Breakdown {
+			printLines([]string{dt.PrintBreakdown(popts)}, out)
+		} else if opts.ByOwner {
+			printLines(dt.PrintOwners(popts), out)
+		} else if opts.ByGroup {
+			printLines(dt.PrintGroups(popts), out)
+		} else if opts.Histogram {
+			printLines(dt.PrintHistogram(popts), out)
+		} else if opts.DepthSummary {
+			printLines(dt.PrintDepthSummary(popts), out)
+		} else if opts.Compression {
+			printLines(dt.PrintCompression(popts), out)
+		} else {
+			printLines(dt.Print(popts), out)
+		}
+	}
+}
+
+// printZeroByteFiles writes out the report of the empty files in `dt` with
+// --zero-byte-report.
+func printZeroByteFiles(dt *dirtree.DirTree, popts dirtree.PrintOptions, out io.Writer) {
+	if opts.ZeroByteReport != "" {
+		printLines(dt.PrintZeroByteFiles(popts, opts.ZeroByteReport == "list"), out)
+	}
+}
+
+// verify scans `dt`, the tree of `file`, once more, starting from what was
+// `counted` before it, and warns if its total has changed since it was
+// scanned, see dirtree.Scanner.Verify.
+func verify(scanner *dirtree.Scanner, dt *dirtree.DirTree, counted dirtree.Counted, file string) {
+	if _, changed := scanner.Verify(dt, counted); changed {
+		errLog.Printf("the total of '%s' changed during the scan, the sizes are approximate", file)
+	}
+}
+
+// resultLimit passes at most `max` entries on to `w` and drops the rest, see
+// --max-results. Each entry is written with a single call to Write, as
+// printLines does.
+type resultLimit struct {
+	w   io.Writer
+	max int
+	// The number of entries written so far, including the dropped ones.
+	n int
+}
+
+// Write implements io.Writer.
+func (l *resultLimit) Write(p []byte) (int, error) {
+	l.n++
+	if l.n > l.max {
+		return len(p), nil
+	}
+	return l.w.Write(p)
+}
+
+// notice tells on stderr how many entries were dropped, if any. It is kept
+// out of the output, so that the output stays in the same format.
+func (l *resultLimit) notice() {
+	switch n := l.n - l.max; {
+	case n == 1:
+		errLog.Println("... 1 more entry not shown, see --max-results")
+	case n > 1:
+		errLog.Printf("... %d more entries not shown, see --max-results", n)
+	}
+}
+
+// mergedLines collects the entries written to it and writes them out to `w`
+// on flush, each path only once with the largest size it was written with,
+// in the order the paths first came in, see --merge. Each entry is written
+// with a single call to Write, as printLines does. The lines that don't
+// start with a size, like the reports, are passed on as they are.
+type mergedLines struct {
+	w     io.Writer
+	lines []string
+	sizes []int64
+	// The positions of the paths in lines.
+	index map[string]int
+}
+
+// Write implements io.Writer.
+func (m *mergedLines) Write(p []byte) (int, error) {
+	line := string(p)
+	size, path, ok := splitEntry(strings.TrimSuffix(line, lineEnd()))
+	if !ok {
+		m.lines = append(m.lines, line)
+		m.sizes = append(m.sizes, 0)
+		return len(p), nil
+	}
+	if i, seen := m.index[path]; seen {
+		if size > m.sizes[i] {
+			m.lines[i], m.sizes[i] = line, size
+		}
+		return len(p), nil
+	}
+	m.index[path] = len(m.lines)
+	m.lines = append(m.lines, line)
+	m.sizes = append(m.sizes, size)
+	return len(p), nil
+}
+
+// flush writes out the merged entries.
+func (m *mergedLines) flush() {
+	for _, line := range m.lines {
+		io.WriteString(m.w, line)
+	}
+}
+
+// splitEntry returns the size and the path of the entry printed as `line`,
+// "SIZE\tPATH" or just "PATH" with --paths-only. It fails if the line
+// doesn't start with a size.
+func splitEntry(line string) (int64, string, bool) {
+	i := strings.IndexByte(line, '\t')
+	if i < 0 {
+		return 0, line, true
+	}
+	size, err := strconv.ParseInt(line[:i], 10, 64)
+	return size, line[i+1:], err == nil
+}
+
+// lineEnd returns what the lines of the output end with, a newline or NUL
+// with -0.
+func lineEnd() string {
+	if opts.Null {
+		return "\x00"
+	}
+	return "\n"
+}
+
+// printLines writes out the `lines` to `out`, each on its own line. With
+// -0 the lines end with NUL instead of newline.
+func printLines(lines []string, out io.Writer) {
+	end := lineEnd()
+	for _, s := range lines {
+		fmt.Fprint(out, s, end)
+	}
+}
+
+// browse calculates the disk usage of each of the `files` and lets the user
+// navigate the results interactively, one file after another.
+func browse(files []string) error {
+	popts := printOptions()
+	scanner := newScanner()
+	var trees []*dirtree.DirTree
+	for _, file := range files {
+		if !opts.DedupOperands && !opts.Combine {
+			scanner.Reset()
+		}
+		dt, _ := scanner.Scan(file)
+		clearProgress(os.Stderr)
+		trees = append(trees, dt)
+	}
+
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return fmt.Errorf("cannot browse interactively, stdin is not a terminal: %v", err)
+	}
+	defer restore()
+	for _, dt := range trees {
+		if err := dt.Browse(os.Stdin, os.Stdout, popts); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
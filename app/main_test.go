@@ -1,7 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/iliafrenkel/go-du/app/dirtree"
 )
 
 // The below is needed because "packages that call flag.Parse during package
@@ -21,12 +34,63 @@ func Test_Flags(t *testing.T) {
 		flag bool
 		want bool
 	}{
+		{"--apparent-size", opts.ApparentSize, false},
 		{"-k", opts.BlockSize, false},
+		{"-m", opts.BlockSizeM, false},
+		{"-g", opts.BlockSizeG, false},
+		{"--by-group", opts.ByGroup, false},
+		{"--by-owner", opts.ByOwner, false},
+		{"--human-readable-si-bytes", opts.BytesAndHuman, false},
+		{"--breakdown", opts.Breakdown, false},
+		{"--debug-blocks", opts.DebugBlocks, false},
+		{"--dedup-extents", opts.DedupExtents, false},
+		{"--exact-allocated", opts.ExactAllocated, false},
+		{"--exclude-vcs", opts.ExcludeVCS, false},
+		{"--flat", opts.Flat, false},
+		{"--canonicalize", opts.Canonicalize, false},
+		{"--collapse-operands", opts.CollapseOperands, false},
+		{"--current-summary", opts.CurrentSummary, false},
+		{"--cross-file-systems", opts.CrossFileSystems, false},
+		{"--merge", opts.Merge, false},
+		{"--normalize-unicode", opts.NormalizeUnicode, false},
+		{"--no-block-rounding-for-small-files", opts.NoSmallFileRounding, false},
+		{"--posix-1k", opts.Posix1K, false},
+		{"--summarize-with-children-count", opts.SummarizeChildren, false},
+		{"--summarize-percent", opts.SummarizePercent, false},
+		{"--portable-output", opts.PortableOutput, false},
+		{"--pretty", opts.Pretty, false},
+		{"--histogram", opts.Histogram, false},
+		{"--depth-summary", opts.DepthSummary, false},
+		{"--count-only-regular-files", opts.RegularFilesOnly, false},
+		{"--resolve-mountpoints", opts.ResolveMounts, false},
+		{"--verify", opts.Verify, false},
+		{"--paths-only", opts.PathsOnly, false},
+		{"-0", opts.Null, false},
 		{"-a", opts.CountFiles, false},
+		{"--combine", opts.Combine, false},
+		{"--compression-ratio", opts.Compression, false},
+		{"--dedup-operands", opts.DedupOperands, false},
+		{"--one-file-per-operand", opts.OneFilePerOperand, false},
 		{"-L", opts.DereferenceAll, false},
 		{"-H", opts.DereferenceArgs, false},
+		{"--exclude-dotfiles", opts.ExcludeDotfiles, false},
+		{"--fail-fast", opts.FailFast, false},
+		{"--from-stdin", opts.FromStdin, false},
+		{"--gitignore", opts.GitIgnore, false},
+		{"-h", opts.HumanReadable, false},
+		{"--interactive", opts.Interactive, false},
+		{"-P", opts.NoDereference, false},
+		{"--only-dotfiles", opts.OnlyDotfiles, false},
 		{"-x", opts.OneFileSystem, false},
+		{"--print-real-path", opts.PrintRealPath, false},
+		{"--progress", opts.Progress, false},
+		{"--show-counts", opts.ShowCounts, false},
+		{"--skip-empty-dirs", opts.SkipEmptyDirs, false},
 		{"-s", opts.Summarise, false},
+		{"--total-for-root-only", opts.TotalsOnly, false},
+		{"--total-at-top", opts.TotalAtTop, false},
+		{"--use-frsize", opts.UseFrsize, false},
+		{"--verbose-errors", opts.VerboseErrors, false},
 		{"-v", opts.Version, false},
 	}
 	for _, tt := range tests {
@@ -51,9 +115,1419 @@ func Test_FlagConflicts(t *testing.T) {
 	if !conflictingFlags() {
 		t.Errorf("Expecting conflict between -a and -s flags.")
 	}
+	opts = options{Summarise: true, MaxDepth: 2}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between -s and -d flags.")
+	}
+	opts = options{Summarise: true, MaxDepth: 0}
+	if conflictingFlags() {
+		t.Errorf("Not expecting conflict between -s and -d 0 flags.")
+	}
+	opts = options{DereferenceAll: true, NoDereference: true}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between -L and -P flags.")
+	}
+	opts = options{OneFilePerOperand: true, DedupOperands: true}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --one-file-per-operand and --dedup-operands flags.")
+	}
+	opts = options{DerefDepth: 1, DereferenceArgs: true}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --deref-depth and -H flags.")
+	}
+	opts = options{FromStdin: true, Interactive: true}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --from-stdin and --interactive flags.")
+	}
+	opts = options{ExcludeDotfiles: true, OnlyDotfiles: true}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --exclude-dotfiles and --only-dotfiles flags.")
+	}
+	opts = options{BlockSize: true, BlockSizeG: true}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between -k and -g flags.")
+	}
+	opts = options{ExactAllocated: true, BlockSize: true}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --exact-allocated and -k flags.")
+	}
+	opts = options{DedupExtents: true, ApparentSize: true}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --dedup-extents and --apparent-size flags.")
+	}
+	opts = options{PathsOnly: true, Format: "json"}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --paths-only and --format=json flags.")
+	}
+	opts = options{Pretty: true, Format: "ndjson"}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --pretty and --format=ndjson flags.")
+	}
+	opts = options{ByOwner: true, Format: "json"}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --by-owner and --format=json flags.")
+	}
+	opts = options{ByOwner: true, ByGroup: true, Format: "text"}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --by-owner and --by-group flags.")
+	}
+	opts = options{PadSizes: 8, ZeroPadSizes: 8}
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --pad-sizes and --zero-pad-sizes flags.")
+	}
+	opts = options{DereferenceArgs: true}
+	if conflictingFlags() {
+		t.Errorf("Expecting no conflict for -H alone.")
+	}
 }
 
 func Test_PrintVersion(t *testing.T) {
 	// I don't know what to test here yet.
 	printVersion()
 }
+
+func Test_UseColor(t *testing.T) {
+	var tests = []struct {
+		when    string
+		want    bool
+		wantErr bool
+	}{
+		{"always", true, false},
+		{"never", false, false},
+		{"sometimes", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.when, func(t *testing.T) {
+			got, err := useColor(tt.when, os.Stdout)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Unexpected error for --color=%s: %v", tt.when, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expecting --color=%s to be %v and not %v", tt.when, tt.want, got)
+			}
+		})
+	}
+
+	// /dev/null is a character device, but not a terminal
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer null.Close()
+	if got, _ := useColor("auto", null); got {
+		t.Errorf("Expecting --color=auto to be false for %s", os.DevNull)
+	}
+}
+
+// Creates a file of a given size, creating the parent directories if needed.
+func createFile(t *testing.T, file string, size int64) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	if err := ioutil.WriteFile(file, make([]byte, size), 0644); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+}
+
+// Returns the options with the default values of all the flags.
+func defaultOptions() options {
+	return options{Color: "never", Format: "text", HumanStyle: "short", LeafDepth: -1, MaxDepth: -1, Sort: "none"}
+}
+
+// Returns the size in 512-byte units that is counted for the directory `dir`
+// itself: its size rounded up to whole blocks of its file system.
+func dirUnits(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Lstat(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	size, bs := info.Size(), int64(fs.Bsize)
+	if size == 0 {
+		return 0
+	}
+
+	return (1 + (size-1)/bs) * bs / 512
+}
+
+// Runs the utility for the given files and returns the output lines.
+func runLines(files ...string) []string {
+	var out bytes.Buffer
+	run(files, &out)
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+func Test_HardLinksPerOperand(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "a")
+	b := filepath.Join(root, "b")
+	createFile(t, filepath.Join(a, "over_4k.txt"), 5678)
+	if err := os.Mkdir(b, 0755); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	if err := os.Link(filepath.Join(a, "over_4k.txt"), filepath.Join(b, "link.txt")); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	var tests = []struct {
+		name    string
+		perFile bool
+		dedup   bool
+		want    []string
+	}{
+		{"Once for each operand", false, false, []string{"24\t" + a, "24\t" + b}},
+		{"--one-file-per-operand", true, false, []string{"24\t" + a, "24\t" + b}},
+		{"Once for all operands", false, true, []string{"24\t" + a, "8\t" + b}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts = defaultOptions()
+			opts.OneFilePerOperand = tt.perFile
+			opts.DedupOperands = tt.dedup
+			got := runLines(a, b)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Expecting output %q and not %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_ResolveUnitSize(t *testing.T) {
+	var tests = []struct {
+		name      string
+		opts      options
+		env       map[string]string
+		wantSize  int64
+		wantHuman bool
+		wantErr   bool
+	}{
+		{"Default", options{}, nil, 512, false, false},
+		{"BLOCKSIZE", options{}, map[string]string{"BLOCKSIZE": "2K"}, 2048, false, false},
+		{"BLOCK_SIZE", options{}, map[string]string{"BLOCK_SIZE": "3K", "BLOCKSIZE": "2K"}, 3072, false, false},
+		{"DU_BLOCK_SIZE", options{}, map[string]string{"DU_BLOCK_SIZE": "4K", "BLOCK_SIZE": "3K", "BLOCKSIZE": "2K"}, 4096, false, false},
+		{"Invalid DU_BLOCK_SIZE", options{}, map[string]string{"DU_BLOCK_SIZE": "big", "BLOCK_SIZE": "3K"}, 3072, false, false},
+		{"Human DU_BLOCK_SIZE", options{}, map[string]string{"DU_BLOCK_SIZE": "human-readable"}, 1, true, false},
+		{"-k", options{BlockSize: true}, map[string]string{"DU_BLOCK_SIZE": "4K"}, 1024, false, false},
+		{"-m", options{BlockSizeM: true}, map[string]string{"DU_BLOCK_SIZE": "4K"}, 1 << 20, false, false},
+		{"-g", options{BlockSizeG: true}, map[string]string{"DU_BLOCK_SIZE": "4K"}, 1 << 30, false, false},
+		{"-B", options{UnitSize: "8K", BlockSize: true}, map[string]string{"DU_BLOCK_SIZE": "4K"}, 8192, false, false},
+		{"-B 1m", options{UnitSize: "1m"}, nil, 1 << 20, false, false},
+		{"-B human", options{UnitSize: "human"}, nil, 1, true, false},
+		{"-h", options{HumanReadable: true}, map[string]string{"DU_BLOCK_SIZE": "4K"}, 1, true, false},
+		{"--human-readable-si-bytes", options{BytesAndHuman: true}, nil, 1, true, false},
+		{"--exact-allocated", options{ExactAllocated: true}, map[string]string{"DU_BLOCK_SIZE": "4K"}, 1, false, false},
+		{"-B 0", options{UnitSize: "0"}, nil, 0, false, true},
+		{"-B big", options{UnitSize: "big"}, nil, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(name string) string { return tt.env[name] }
+			size, human, err := resolveUnitSize(tt.opts, getenv)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if size != tt.wantSize || human != tt.wantHuman {
+				t.Errorf("Expecting unit size %d and human %v and not %d and %v", tt.wantSize, tt.wantHuman, size, human)
+			}
+		})
+	}
+}
+
+func Test_MakeRawNotTerminal(t *testing.T) {
+	f, err := ioutil.TempFile(t.TempDir(), "not-a-terminal")
+	if err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer f.Close()
+	if _, err := makeRaw(int(f.Fd())); err == nil {
+		t.Errorf("Expecting an error for a file that is not a terminal")
+	}
+}
+
+func Test_MinSize(t *testing.T) {
+	var tests = []struct {
+		name    string
+		opts    options
+		want    int64
+		wantErr bool
+	}{
+		{"Not set", options{}, 0, false},
+		{"Units", options{MinSize: "10"}, 10 * 512, false},
+		{"Units and -k", options{MinSize: "10", BlockSize: true}, 10 * 1024, false},
+		{"Human", options{MinSize: "10K", HumanReadable: true}, 10 * 1024, false},
+		{"Negative", options{MinSize: "-1"}, 0, true},
+		{"Invalid", options{MinSize: "big"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts = tt.opts
+			got, err := minSize()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expecting --min-size=%s to be %d bytes and not %d", tt.opts.MinSize, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_MtimeRange(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 12, 31, 23, 59, 59, 0, time.UTC)
+	var tests = []struct {
+		value    string
+		from, to time.Time
+		wantErr  bool
+	}{
+		{"", time.Time{}, time.Time{}, false},
+		{"2021-01-01T00:00:00Z,2021-12-31T23:59:59Z", start, end, false},
+		{"2021-01-01T00:00:00Z,", start, time.Time{}, false},
+		{",2021-12-31T23:59:59Z", time.Time{}, end, false},
+		{"2021-12-31T23:59:59Z,2021-01-01T00:00:00Z", time.Time{}, time.Time{}, true},
+		{"2021-01-01T00:00:00Z", time.Time{}, time.Time{}, true},
+		{"yesterday,today", time.Time{}, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			opts = options{MtimeBetween: tt.value}
+			from, to, err := mtimeRange()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !tt.wantErr && (!from.Equal(tt.from) || !to.Equal(tt.to)) {
+				t.Errorf("Expecting the range %v - %v and not %v - %v", tt.from, tt.to, from, to)
+			}
+		})
+	}
+}
+
+func Test_ProgressPrinter(t *testing.T) {
+	var out bytes.Buffer
+	p := progressPrinter(&out)
+	for _, v := range []float64{10, 10.5, 50, 100} {
+		p(v)
+	}
+	if want := "\rScanning...  10%\rScanning...  50%\rScanning... 100%"; out.String() != want {
+		t.Errorf("Expecting progress %q and not %q", want, out.String())
+	}
+}
+
+func Test_Combine(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "a")
+	b := filepath.Join(root, "b")
+	createFile(t, filepath.Join(a, "over_4k.txt"), 5678)
+	createFile(t, filepath.Join(b, "under_4k.txt"), 3456)
+	if err := os.Link(filepath.Join(a, "over_4k.txt"), filepath.Join(b, "link.txt")); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+
+	opts = defaultOptions()
+	opts.Combine = true
+	got := runLines(a, b)
+	want := []string{"24\t" + a, "16\t" + b, "40\ttotal"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+
+	// The grand total comes first as well
+	opts.TotalAtTop = true
+	got = runLines(a, b)
+	want = []string{"40\ttotal", "24\t" + a, "16\t" + b}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q with --total-at-top and not %q", want, got)
+	}
+}
+
+func Test_VerifyHardLinks(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "a")
+	e := filepath.Join(root, "e")
+	createFile(t, filepath.Join(a, "over_4k.txt"), 5678)
+	if err := os.Mkdir(e, 0755); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	if err := os.Link(filepath.Join(a, "over_4k.txt"), filepath.Join(e, "link.txt")); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	var buf bytes.Buffer
+	errLog.SetOutput(&buf)
+	defer errLog.SetOutput(os.Stderr)
+
+	for _, tt := range []struct {
+		name     string
+		set      func(o *options)
+		operands []string
+	}{
+		{"--dedup-operands", func(o *options) { o.DedupOperands = true }, []string{a, a}},
+		{"--combine", func(o *options) { o.Combine = true }, []string{a, e}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			opts = defaultOptions()
+			opts.Summarise = true
+			opts.Verify = true
+			tt.set(&opts)
+			runLines(tt.operands...)
+			if strings.Contains(buf.String(), "changed during the scan") {
+				t.Errorf("Expecting the total not to change for the hard links counted before and not %q", buf.String())
+			}
+		})
+	}
+}
+
+func Test_Diff(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "dir")
+	createFile(t, filepath.Join(dir, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(dir, "old", "under_4k.txt"), 3456)
+
+	// Save the first scan
+	opts = defaultOptions()
+	opts.Format = "json"
+	saved := filepath.Join(root, "previous.json")
+	if err := ioutil.WriteFile(saved, []byte(strings.Join(runLines(dir), "\n")), 0644); err != nil {
+		t.Fatalf("Failed to save the scan: %v", err)
+	}
+
+	createFile(t, filepath.Join(dir, "new", "over_4k.txt"), 5678)
+	if err := os.RemoveAll(filepath.Join(dir, "old")); err != nil {
+		t.Fatalf("Failed to change test data: %v", err)
+	}
+	opts = defaultOptions()
+	opts.Diff = saved
+	got := runLines(dir)
+	want := []string{"+24\t" + dir + "/new (new)", "+8\t" + dir, "-16\t" + dir + "/old (removed)"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+
+	opts.Diff = filepath.Join(root, "missing.json")
+	if err := run([]string{dir}, ioutil.Discard); err == nil {
+		t.Errorf("Expecting an error for a missing file")
+	}
+}
+
+func Test_MaxDepthFiles(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(root, "subdir", "over_4k.txt"), 5678)
+
+	opts = defaultOptions()
+	opts.CountFiles = true
+	opts.MaxDepth = 1
+	got := runLines(root)
+	want := []string{"8\t" + root + "/under_4k.txt", "24\t" + root + "/subdir", "40\t" + root}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+
+	opts.MaxDepth = 0
+	opts.CountFiles = false
+	if got := runLines(root); len(got) != 1 || got[0] != "40\t"+root {
+		t.Errorf("Expecting --max-depth=0 to summarise and not %q", got)
+	}
+}
+
+func Test_MaxDepthUnlimited(t *testing.T) {
+	var tests = []struct {
+		arg     string
+		want    maxDepth
+		wantErr bool
+	}{
+		{"inf", -1, false},
+		{"Infinity", -1, false},
+		{"unlimited", -1, false},
+		{"-1", -1, false},
+		{"-5", -1, false},
+		{"0", 0, false},
+		{"3", 3, false},
+		{"deep", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			var d maxDepth
+			err := d.Set(tt.arg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if d != tt.want {
+				t.Errorf("Expecting depth %d and not %d", tt.want, d)
+			}
+		})
+	}
+
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "a", "b", "over_4k.txt"), 5678)
+	opts = defaultOptions()
+	want := runLines(root)
+	if err := opts.MaxDepth.Set("inf"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := runLines(root); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting -d inf to print %q and not %q", want, got)
+	}
+}
+
+func Test_MaxDepth(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "a", "b", "c", "over_4k.txt"), 5678)
+	opts = defaultOptions()
+	if err := flag.Lookup("d").Value.Set("2"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var got []string
+	for _, l := range runLines(root) {
+		got = append(got, l[strings.Index(l, "\t")+1:])
+	}
+	want := []string{filepath.Join(root, "a", "b"), filepath.Join(root, "a"), root}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting -d 2 to print %q and not %q", want, got)
+	}
+}
+
+func Test_TotalsOnly(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "a")
+	b := filepath.Join(root, "b")
+	createFile(t, filepath.Join(a, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(a, "subdir", "over_4k.txt"), 5678)
+	createFile(t, filepath.Join(b, "under_4k.txt"), 1234)
+
+	opts = defaultOptions()
+	opts.CountFiles = true
+	opts.TotalsOnly = true
+	got := runLines(a, b)
+	want := []string{"40\t" + a, "16\t" + b}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+}
+
+func Test_RunScanErrors(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "under_4k.txt"), 3456)
+
+	opts = defaultOptions()
+	if err := run([]string{root}, ioutil.Discard); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	errLog.SetOutput(ioutil.Discard)
+	defer errLog.SetOutput(os.Stderr)
+	if err := run([]string{root, filepath.Join(root, "missing")}, ioutil.Discard); err != errOperand {
+		t.Errorf("Expecting %v for a missing file and not %v", errOperand, err)
+	}
+	// Neither while streaming the output nor once the tree is scanned,
+	// as it is with -h, which has to align the sizes
+	for _, human := range []bool{false, true} {
+		opts.HumanReadable = human
+		if got := runLines(filepath.Join(root, "missing")); len(got) != 1 || got[0] != "" {
+			t.Errorf("Expecting nothing to be printed for a missing file (-h: %v) and not %q", human, got)
+		}
+	}
+	opts.HumanReadable = false
+
+	// Nothing is printed after the first error with --fail-fast
+	opts.FailFast = true
+	if got := runLines(filepath.Join(root, "missing"), root); len(got) != 1 || got[0] != "" {
+		t.Errorf("Expecting no output after the first error and not %q", got)
+	}
+}
+
+func Test_RunUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Permissions are not enforced for root")
+	}
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "subdir", "under_4k.txt"), 3456)
+	if err := os.Chmod(filepath.Join(root, "subdir"), 0); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer os.Chmod(filepath.Join(root, "subdir"), 0755)
+
+	opts = defaultOptions()
+	errLog.SetOutput(ioutil.Discard)
+	defer errLog.SetOutput(os.Stderr)
+	if err := run([]string{root}, ioutil.Discard); err != errScan {
+		t.Errorf("Expecting %v for an unreadable directory and not %v", errScan, err)
+	}
+}
+
+func Test_RunUnreadableRoot(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Permissions are not enforced for root")
+	}
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "subdir", "under_4k.txt"), 3456)
+	size := dirUnits(t, root)
+	if err := os.Chmod(root, 0311); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer os.Chmod(root, 0755)
+
+	// The error message itself is printed by the scanner
+	opts = defaultOptions()
+	var out bytes.Buffer
+	err := run([]string{root}, &out)
+	if code := exitCode(err); code != exitScan {
+		t.Errorf("Expecting exit status %d for an unreadable argument and not %d", exitScan, code)
+	}
+	// Only the size of the directory itself, nothing in it
+	if want := strconv.FormatInt(size, 10) + "\t" + root + "\n"; out.String() != want {
+		t.Errorf("Expecting output %q and not %q", want, out.String())
+	}
+}
+
+func Test_ReportInaccessible(t *testing.T) {
+	var r reportTarget
+	for _, tt := range []struct{ v, want string }{{"true", "-"}, {"report.txt", "report.txt"}, {"false", ""}} {
+		if r.Set(tt.v); string(r) != tt.want {
+			t.Errorf("Expecting %q for --report-inaccessible=%s and not %q", tt.want, tt.v, r)
+		}
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("Permissions are not enforced for root")
+	}
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(root, "subdir", "under_4k.txt"), 3456)
+	if err := os.Chmod(filepath.Join(root, "subdir"), 0); err != nil {
+		t.Fatalf("Failed to create test data: %v", err)
+	}
+	defer os.Chmod(filepath.Join(root, "subdir"), 0755)
+	report := filepath.Join(t.TempDir(), "inaccessible.txt")
+
+	opts = defaultOptions()
+	opts.ReportInaccessible = reportTarget(report)
+	errLog.SetOutput(ioutil.Discard)
+	defer errLog.SetOutput(os.Stderr)
+	if err := run([]string{root}, ioutil.Discard); err != errScan {
+		t.Errorf("Expecting %v for an unreadable directory and not %v", errScan, err)
+	}
+	out, err := ioutil.ReadFile(report)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "cannot read directory '" + filepath.Join(root, "subdir") + "': permission denied\n"
+	if string(out) != want {
+		t.Errorf("Expecting the inaccessible paths %q and not %q", want, out)
+	}
+}
+
+func Test_ExitCode(t *testing.T) {
+	var tests = []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOK},
+		{errScan, exitScan},
+		{errOperand, exitFatal},
+		{errors.New("the scan timed out"), exitFatal},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("Expecting exit status %d for %v and not %d", tt.want, tt.err, got)
+		}
+	}
+}
+
+func Test_Jobs(t *testing.T) {
+	defer func() { numCPU = runtime.NumCPU }()
+	var tests = []struct {
+		cpus int
+		jobs int
+		want int
+	}{
+		{1, 0, 1},
+		{2, 0, 2},
+		{16, 0, maxDefaultJobs},
+		{16, 8, 8},
+		{2, 1, 1},
+	}
+	for _, tt := range tests {
+		numCPU = func() int { return tt.cpus }
+		opts = defaultOptions()
+		opts.Jobs = tt.jobs
+		if got := jobs(); got != tt.want {
+			t.Errorf("Expecting %d jobs with %d CPUs and --jobs=%d and not %d", tt.want, tt.cpus, tt.jobs, got)
+		}
+	}
+}
+
+func Test_RunParallel(t *testing.T) {
+	root := t.TempDir()
+	var files []string
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		createFile(t, filepath.Join(root, name, "sub", "over_4k.txt"), 5678)
+		files = append(files, filepath.Join(root, name))
+	}
+	files = append(files, filepath.Join(root, "missing"))
+
+	errLog.SetOutput(ioutil.Discard)
+	defer errLog.SetOutput(os.Stderr)
+	opts = defaultOptions()
+	opts.HumanReadable = true
+	opts.Jobs = 1
+	want := runLines(files...)
+	opts.Jobs = 3
+	if got := runLines(files...); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting the same output as --jobs=1 %q and not %q", want, got)
+	}
+	if err := run(files, ioutil.Discard); err != errOperand {
+		t.Errorf("Expecting %v for a missing file and not %v", errOperand, err)
+	}
+}
+
+func Test_ScanTimeout(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "under_4k.txt"), 3456)
+
+	opts = defaultOptions()
+	opts.ScanTimeout = time.Nanosecond
+	err := run([]string{root, root}, ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expecting a timeout error and not %v", err)
+	}
+}
+
+func Test_OpTimeout(t *testing.T) {
+	opts = defaultOptions()
+	opts.OpTimeout = 10 * time.Second
+	if s := newScanner(); s.OpTimeout != opts.OpTimeout {
+		t.Errorf("Expecting the scanner to time out after %v and not %v", opts.OpTimeout, s.OpTimeout)
+	}
+	opts.OpTimeout = -time.Second
+	if err := checkFlags(); err == nil {
+		t.Errorf("Expecting an error for a negative --op-timeout")
+	}
+}
+
+func Test_SortOperands(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "a")
+	b := filepath.Join(root, "b")
+	c := filepath.Join(root, "c")
+	createFile(t, filepath.Join(a, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(b, "over_4m.txt"), 5678*1024)
+	createFile(t, filepath.Join(c, "over_4k.txt"), 5678)
+
+	opts = defaultOptions()
+	opts.Summarise = true
+	opts.Sort = "size"
+	got := runLines(a, b, c)
+	want := []string{"11368\t" + b, "24\t" + c, "16\t" + a}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+
+	opts.Reverse = true
+	got = runLines(a, b, c)
+	want = []string{"16\t" + a, "24\t" + c, "11368\t" + b}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+
+	// The most recently modified first
+	for i, p := range []string{filepath.Join(b, "over_4m.txt"), b, filepath.Join(c, "over_4k.txt"), c, filepath.Join(a, "under_4k.txt"), a} {
+		mtime := time.Now().Add(time.Duration(i/2-10) * time.Minute)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatalf("Failed to create test data: %v", err)
+		}
+	}
+	opts.Reverse = false
+	opts.Sort = "mtime"
+	got = runLines(a, b, c)
+	want = []string{"16\t" + a, "24\t" + c, "11368\t" + b}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+
+	// The most files first
+	createFile(t, filepath.Join(c, "under_4k.txt"), 3456)
+	opts.Sort = "count"
+	opts.ShowCounts = true
+	got = runLines(a, b, c)
+	want = []string{"32\t2\t" + c, "16\t1\t" + a, "11368\t1\t" + b}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+
+	opts.Sort = "none"
+	opts.Reverse = true
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between -r and --sort=none flags.")
+	}
+	opts.Reverse = false
+	opts.ShowCounts = false
+	opts.Sort = "none"
+	got = runLines(a, b, c)
+	want = []string{"16\t" + a, "11368\t" + b, "32\t" + c}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+}
+
+func Test_ReadOperands(t *testing.T) {
+	in := "a\n\nb c\r\n/tmp/d\n\n"
+	got, err := readOperands(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"a", "b c", "/tmp/d"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expecting operands %q and not %q", want, got)
+	}
+}
+
+func Test_CollapseOperands(t *testing.T) {
+	var tests = []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{"Nested", []string{"a", "a/b"}, []string{"a"}},
+		{"Nested first", []string{"a/b/c", "x", "a/"}, []string{"x", "a/"}},
+		{"Same prefix", []string{"a", "ab"}, []string{"a", "ab"}},
+		{"Duplicate", []string{"./a", "a"}, []string{"./a"}},
+		{"Root", []string{"/tmp", "/"}, []string{"/"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := collapseOperands(tt.files); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Expecting operands %q and not %q", tt.want, got)
+			}
+		})
+	}
+
+	// a/b is only scanned as part of a
+	root := t.TempDir()
+	a := filepath.Join(root, "a")
+	createFile(t, filepath.Join(a, "b", "over_4k.txt"), 5678)
+	opts = defaultOptions()
+	got := runLines(collapseOperands([]string{a, filepath.Join(a, "b")})...)
+	want := runLines(a)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	if len(got) != 2 {
+		t.Errorf("Expecting a/b to be printed once and not %q", got)
+	}
+}
+
+func Test_ChangedSinceFile(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "old.txt"), 3456)
+	createFile(t, filepath.Join(root, "timestamp"), 0)
+	createFile(t, filepath.Join(root, "new.txt"), 5678)
+	now := time.Now()
+	for i, name := range []string{"old.txt", "timestamp", "new.txt"} {
+		mtime := now.Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(filepath.Join(root, name), mtime, mtime); err != nil {
+			t.Fatalf("Failed to create test data: %v", err)
+		}
+	}
+
+	opts = defaultOptions()
+	opts.CountFiles = true
+	opts.PathsOnly = true
+	opts.ChangedSinceFile = filepath.Join(root, "timestamp")
+	if err := checkFlags(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got := runLines(root)
+	want := []string{filepath.Join(root, "new.txt"), root}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+
+	opts.ChangedSinceFile = filepath.Join(root, "missing")
+	if err := checkFlags(); err == nil {
+		t.Errorf("Expecting an error for a missing reference file")
+	}
+}
+
+func Test_ByOwner(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(root, "subdir", "over_4k.txt"), 5678)
+
+	opts = defaultOptions()
+	opts.Summarise = true
+	total := runLines(root)
+	opts.ByOwner = true
+	got := runLines(root)
+	// Everything belongs to the user running the tests
+	size := strings.SplitN(total[0], "\t", 2)[0]
+	if len(got) != 1 || !strings.HasSuffix(got[0], "\t"+size) {
+		t.Errorf("Expecting a single owner of %s units and not %q", size, got)
+	}
+	opts.ByOwner = false
+	opts.ByGroup = true
+	if got := runLines(root); len(got) != 1 || !strings.HasSuffix(got[0], "\t"+size) {
+		t.Errorf("Expecting a single group of %s units and not %q", size, got)
+	}
+}
+
+func Test_HumanStyle(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "4k.txt")
+	createFile(t, file, 4096)
+
+	var tests = []struct {
+		style string
+		want  string
+	}{
+		{"short", "4.0K"},
+		{"iec", "4.0KiB"},
+		{"space", "4.0 K"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.style, func(t *testing.T) {
+			opts = defaultOptions()
+			opts.ApparentSize = true
+			opts.HumanReadable = true
+			opts.HumanStyle = tt.style
+			if got, want := runLines(file), []string{tt.want + "\t" + file}; strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("Expecting output %q and not %q", want, got)
+			}
+		})
+	}
+	opts.HumanStyle = "long"
+	if err := checkFlags(); err == nil {
+		t.Errorf("Expecting an error for --human-style=long")
+	}
+}
+
+func Test_DedupExtents(t *testing.T) {
+	opts = defaultOptions()
+	opts.DedupExtents = true
+	if err := checkFlags(); (err == nil) != dirtree.DedupExtentsSupported {
+		t.Errorf("Expecting --dedup-extents to be valid only in the builds that support it and not %v", err)
+	}
+}
+
+func Test_Flat(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(root, "subdir", "over_4k.txt"), 5678)
+
+	opts = defaultOptions()
+	opts.Flat = true
+	got := runLines(root)
+	want := []string{
+		"8\t" + filepath.Join(root, "under_4k.txt"),
+		"16\t" + filepath.Join(root, "subdir", "over_4k.txt"),
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting no directories in the output %q and not %q", want, got)
+	}
+	opts.Summarise = true
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --flat and -s flags.")
+	}
+}
+
+func Test_Posix1K(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(root, "subdir", "over_4m.txt"), 5678901)
+
+	// The 1K blocks are rounded up from the 4K filesystem blocks
+	opts = defaultOptions()
+	opts.Posix1K = true
+	opts.CountFiles = true
+	got := runLines(root)
+	want := []string{
+		"4 " + filepath.Join(root, "under_4k.txt"),
+		"5548 " + filepath.Join(root, "subdir", "over_4m.txt"),
+		"5552 " + filepath.Join(root, "subdir"),
+		"5560 " + root,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	opts.HumanReadable = true
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --posix-1k and -h flags.")
+	}
+}
+
+func Test_SummarizePercent(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(root, "subdir", "over_4k.txt"), 5678)
+
+	opts = defaultOptions()
+	opts.SummarizePercent = true
+	got := runLines(root)
+	want := []string{"60%\t" + filepath.Join(root, "subdir"), "100%\t" + root}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	opts.HumanReadable = true
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --summarize-percent and -h flags.")
+	}
+}
+
+func Test_MaxResults(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		createFile(t, filepath.Join(root, name), 3456)
+	}
+
+	var notice bytes.Buffer
+	errLog.SetOutput(&notice)
+	defer errLog.SetOutput(os.Stderr)
+
+	opts = defaultOptions()
+	opts.CountFiles = true
+	opts.MaxResults = 2
+	got := runLines(root)
+	want := []string{
+		"8\t" + filepath.Join(root, "a.txt"),
+		"8\t" + filepath.Join(root, "b.txt"),
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	if want := "... 3 more entries not shown, see --max-results\n"; notice.String() != want {
+		t.Errorf("Expecting the notice %q and not %q", want, notice.String())
+	}
+	notice.Reset()
+	opts.MaxResults = 4
+	runLines(root)
+	if want := "... 1 more entry not shown, see --max-results\n"; notice.String() != want {
+		t.Errorf("Expecting the notice %q and not %q", want, notice.String())
+	}
+	// The notice is not part of the machine readable output
+	notice.Reset()
+	opts.Format = "ndjson"
+	opts.MaxResults = 1
+	if got = runLines(root); len(got) != 1 || !strings.HasPrefix(got[0], "{") {
+		t.Errorf("Expecting one JSON line and not %q", got)
+	}
+	opts.Format = "text"
+	// Nothing is said if all of them fit
+	notice.Reset()
+	opts.MaxResults = 5
+	if got = runLines(root); len(got) != 5 || got[4] != "40\t"+root || notice.Len() != 0 {
+		t.Errorf("Expecting all the entries without a notice and not %q, %q", got, notice.String())
+	}
+}
+
+func Test_ZeroByteReport(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(root, "empty.txt"), 0)
+	createFile(t, filepath.Join(root, "subdir", "empty.txt"), 0)
+
+	opts = defaultOptions()
+	opts.Summarise = true
+	if err := opts.ZeroByteReport.Set("true"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got := runLines(root)
+	want := []string{"24\t" + root, "zero-byte files: 2\t" + root}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	opts.ZeroByteReport.Set("list")
+	got = runLines(root)
+	want = []string{"24\t" + root, filepath.Join(root, "empty.txt"), filepath.Join(root, "subdir", "empty.txt"), "zero-byte files: 2\t" + root}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	if err := opts.ZeroByteReport.Set("all"); err == nil {
+		t.Errorf("Expecting an error for --zero-byte-report=all")
+	}
+}
+
+func Test_SummaryOut(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "a")
+	b := filepath.Join(root, "b")
+	createFile(t, filepath.Join(a, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(b, "subdir", "over_4k.txt"), 5678)
+	summary := filepath.Join(root, "summary.txt")
+
+	opts = defaultOptions()
+	opts.BlockSize = true
+	opts.Summarise = true
+	want := runLines(a, b)
+	opts.SummaryOut = summary
+	if got := runLines(a, b); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting the output %q not to change and not %q", want, got)
+	}
+	out, err := ioutil.ReadFile(summary)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The totals are in bytes rather than in the units of the output
+	var totals []string
+	for _, l := range want {
+		fields := strings.SplitN(l, "\t", 2)
+		size, _ := strconv.ParseInt(fields[0], 10, 64)
+		totals = append(totals, strconv.FormatInt(size*1024, 10)+" "+fields[1])
+	}
+	if got := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"); strings.Join(got, "\n") != strings.Join(totals, "\n") {
+		t.Errorf("Expecting the summary %q and not %q", totals, got)
+	}
+}
+
+func Test_PadSizes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "under_4k.txt")
+	createFile(t, file, 3456)
+
+	opts = defaultOptions()
+	opts.ApparentSize = true
+	opts.UnitSize = "1"
+	opts.ZeroPadSizes = 6
+	if got, want := runLines(file), []string{"003456\t" + file}; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	opts.ZeroPadSizes = 0
+	opts.PadSizes = 6
+	if got, want := runLines(file), []string{"  3456\t" + file}; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+}
+
+func Test_PathsOnly(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(root, "subdir", "over_4k.txt"), 5678)
+	createFile(t, filepath.Join(root, ".hidden"), 1234)
+
+	opts = defaultOptions()
+	opts.CountFiles = true
+	opts.PathsOnly = true
+	opts.ExcludeDotfiles = true
+	opts.Sort = "size"
+	got := runLines(root)
+	want := []string{
+		filepath.Join(root, "under_4k.txt"),
+		filepath.Join(root, "subdir", "over_4k.txt"),
+		filepath.Join(root, "subdir"),
+		root,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+
+	// NUL-separated
+	opts.Null = true
+	var out bytes.Buffer
+	if err := run([]string{root}, &out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := strings.Join(want, "\x00") + "\x00"; out.String() != want {
+		t.Errorf("Expecting output %q and not %q", want, out.String())
+	}
+}
+
+func Test_CanonicalOperands(t *testing.T) {
+	got := canonicalOperands([]string{"a/../b/", "./c//d/.", "/tmp/../e", "."})
+	want := []string{"b", "c/d", "/e", "."}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expecting operands %q and not %q", want, got)
+	}
+
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "a", "b", "over_4k.txt"), 5678)
+	opts = defaultOptions()
+	opts.CountFiles = true
+	for _, l := range runLines(canonicalOperands([]string{root + "/a/../a/"})...) {
+		path := l[strings.Index(l, "\t")+1:]
+		if strings.Contains(path, "..") || strings.HasSuffix(path, "/") || !strings.HasPrefix(path, filepath.Join(root, "a")) {
+			t.Errorf("Expecting a clean path and not %q", path)
+		}
+	}
+}
+
+func Test_CurrentSummary(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "a", "over_4k.txt"), 5678)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	// Like GNU du, every sub-directory of the current one is printed
+	opts = defaultOptions()
+	files, err := operands(nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got := runLines(files...)
+	want := []string{"24\t./a", "32\t."}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+
+	// Just the total with --current-summary
+	opts.CurrentSummary = true
+	summariseCurrent(nil)
+	if files, err = operands(nil, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got = runLines(files...)
+	want = []string{"32\t."}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+
+	// The same with -s, the implicit operand is printed as GNU du does,
+	// the hidden files in it with the ./ prefix like the others
+	opts = defaultOptions()
+	opts.Summarise = true
+	if files, err = operands(nil, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got = runLines(files...); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	createFile(t, filepath.Join(root, ".hidden"), 100)
+	opts = defaultOptions()
+	opts.CountFiles = true
+	got = runLines(files...)
+	want = []string{"8\t./.hidden", "16\t./a/over_4k.txt", "24\t./a", "40\t."}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+
+	// It conflicts with the same flags as -s
+	for _, set := range []func(){
+		func() { opts.CountFiles = true },
+		func() { opts.Flat = true },
+		func() { opts.MaxDepth = 1 },
+	} {
+		opts = defaultOptions()
+		opts.CurrentSummary = true
+		set()
+		summariseCurrent(nil)
+		if !conflictingFlags() {
+			t.Errorf("Expecting a conflict with --current-summary for %+v", opts)
+		}
+	}
+
+	// The arguments are not affected
+	opts = defaultOptions()
+	opts.CurrentSummary = true
+	summariseCurrent([]string{"a", "."})
+	if files, err = operands([]string{"a", "."}, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.Summarise || strings.Join(files, "|") != "a|." {
+		t.Errorf("Expecting the arguments a and . not to be summarised and not %q", files)
+	}
+}
+
+func Test_FormatCSV(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(root, "a,b.txt"), 5678)
+
+	opts = defaultOptions()
+	opts.Format = "csv"
+	opts.CountFiles = true
+	got := runLines(root)
+	want := []string{`16,"` + filepath.Join(root, "a,b.txt") + `"`, "8," + filepath.Join(root, "under_4k.txt"), "32," + root}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	opts.HumanReadable = true
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --format=csv and -h flags.")
+	}
+}
+
+func Test_Prefix(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "sub", "under_4k.txt"), 3456)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	opts = defaultOptions()
+	opts.Prefix = "/backup"
+	got := runLines("sub")
+	want := []string{"16\t/backup/sub"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+}
+
+func Test_RootOneFileSystem(t *testing.T) {
+	opts = defaultOptions()
+	for _, file := range []string{"/", "//", "/.", "/tmp/.."} {
+		if !oneFileSystem(file) {
+			t.Errorf("Expecting %q to be scanned on one file system", file)
+		}
+	}
+	if oneFileSystem("/tmp") {
+		t.Errorf("Expecting /tmp to be scanned across the file systems")
+	}
+	opts.CrossFileSystems = true
+	if oneFileSystem("/") {
+		t.Errorf("Expecting / to be scanned across the file systems with --cross-file-systems")
+	}
+	opts.OneFileSystem = true
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between -x and --cross-file-systems flags.")
+	}
+	opts = defaultOptions()
+	opts.OneFileSystem = true
+	if !oneFileSystem("/tmp") {
+		t.Errorf("Expecting /tmp to be scanned on one file system with -x")
+	}
+}
+
+func Test_DepthSummary(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "a", "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(root, "b", "nested", "over_4k.txt"), 5678)
+
+	opts = defaultOptions()
+	opts.DepthSummary = true
+	got := runLines(root)
+	want := []string{"56\t1\t0\t" + root, "48\t2\t1\t" + root, "24\t1\t2\t" + root}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	opts.Format = "json"
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --depth-summary and --format=json flags.")
+	}
+}
+
+func Test_TimeThreshold(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "new.txt"), 3456)
+	createFile(t, filepath.Join(root, "archive", "old.txt"), 3456)
+	old := time.Now().Add(-100 * 24 * time.Hour)
+	for _, name := range []string{"archive/old.txt", "archive"} {
+		if err := os.Chtimes(filepath.Join(root, name), old, old); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	opts = defaultOptions()
+	opts.TimeThreshold = 90 * 24 * time.Hour
+	got := runLines(root)
+	want := []string{"16\t" + filepath.Join(root, "archive") + " [stale]", "32\t" + root}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	opts.TimeThreshold = -time.Hour
+	if err := checkFlags(); err == nil {
+		t.Errorf("Expecting an error for a negative --time-threshold")
+	}
+}
+
+func Test_NoSmallFileRounding(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"msg1", "msg2", "msg3", "msg4"} {
+		createFile(t, filepath.Join(root, "cur", name), 256)
+	}
+	createFile(t, filepath.Join(root, "over_4k.txt"), 5678)
+
+	opts = defaultOptions()
+	opts.BlockSize = true
+	opts.NoSmallFileRounding = true
+	// Each message takes a 1K unit instead of a 4K block, the directories
+	// and the bigger files are still rounded to the blocks
+	got := runLines(root)
+	want := []string{"8\t" + filepath.Join(root, "cur"), "20\t" + root}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	opts.ApparentSize = true
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --no-block-rounding-for-small-files and --apparent-size flags.")
+	}
+}
+
+func Test_Merge(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	createFile(t, filepath.Join(root, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(sub, "over_4k.txt"), 5678)
+
+	opts = defaultOptions()
+	opts.Merge = true
+	got := runLines(sub, root, sub)
+	want := []string{"24\t" + sub, "40\t" + root}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	// The largest size is kept
+	var buf bytes.Buffer
+	m := &mergedLines{w: &buf, index: make(map[string]int)}
+	printLines([]string{"8\ta", "16\tb", "24\ta", "16\ta", "zero-byte files: 0\ta"}, m)
+	m.flush()
+	if want := "24\ta\n16\tb\nzero-byte files: 0\ta\n"; buf.String() != want {
+		t.Errorf("Expecting the merged output %q and not %q", want, buf.String())
+	}
+	opts.HumanReadable = true
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --merge and -h flags.")
+	}
+}
+
+func Test_DebugBlocks(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "under_4k.txt")
+	createFile(t, file, 3456)
+
+	opts = defaultOptions()
+	opts.DebugBlocks = true
+	got := runLines(file)
+	info, err := os.Lstat(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"8\t" + strconv.FormatInt(info.Sys().(*syscall.Stat_t).Blocks, 10) + "\t" + file}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	opts.Posix1K = true
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict between --debug-blocks and --posix-1k flags.")
+	}
+}
+
+func Test_SummarizeChildren(t *testing.T) {
+	root := t.TempDir()
+	createFile(t, filepath.Join(root, "under_4k.txt"), 3456)
+	createFile(t, filepath.Join(root, "over_4k.txt"), 5678)
+	createFile(t, filepath.Join(root, "subdir", "under_4k.txt"), 3456)
+
+	opts = defaultOptions()
+	opts.Summarise = true
+	opts.SummarizeChildren = true
+	got := runLines(root, filepath.Join(root, "subdir"))
+	want := []string{"48\t3 items\t" + root, "16\t1 items\t" + filepath.Join(root, "subdir")}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting output %q and not %q", want, got)
+	}
+	opts.Summarise = false
+	if !conflictingFlags() {
+		t.Errorf("Expecting conflict for --summarize-with-children-count without -s.")
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// rawSupported tells if the terminal can be put into raw mode, which browsing
+// interactively needs.
+const rawSupported = true
+
+// makeRaw puts the terminal `fd` into raw mode, so that key presses can be
+// read one by one as they come and are not echoed back. Returns a function
+// that restores the previous state of the terminal.
+//
+// See https://man7.org/linux/man-pages/man3/termios.3.html
+func makeRaw(fd int) (func(), error) {
+	var old syscall.Termios
+	if err := termios(fd, syscall.TCGETS, &old); err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := termios(fd, syscall.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+
+	return func() { termios(fd, syscall.TCSETS, &old) }, nil
+}
+
+// isTerminal tells if `fd` is a terminal, that is if its terminal attributes
+// can be read. Unlike os.ModeCharDevice it is false for /dev/null.
+func isTerminal(fd int) bool {
+	var t syscall.Termios
+	return termios(fd, syscall.TCGETS, &t) == nil
+}
+
+// termios gets or sets, depending on `req`, the attributes of the terminal
+// `fd`.
+func termios(fd int, req uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
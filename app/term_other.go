@@ -0,0 +1,25 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// rawSupported tells if the terminal can be put into raw mode, which browsing
+// interactively needs. Only the Linux termios ioctls are implemented.
+const rawSupported = false
+
+// makeRaw always fails, raw mode is not supported on this system.
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw mode is not supported on this system")
+}
+
+// isTerminal is always false, there is no way to tell a terminal apart on this
+// system, so nothing is colored automatically.
+func isTerminal(fd int) bool {
+	return false
+}